@@ -1,41 +1,134 @@
 package natsprovider
 
-import "github.com/nats-io/nats.go"
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
 
-func NewNATSProviderWithAuth(url, username, password, kvStoreName, objStoreName, streamName string) (Provider, error) {
-	nc, err := nats.Connect(url, nats.UserInfo(username, password))
-	if err != nil {
-		return nil, err
-	}
-	js, err := nc.JetStream()
-	if err != nil {
-		return nil, err
-	}
+	"github.com/nats-io/nats.go"
+)
 
-	core := &coreProvider{nc: nc}
-	kv, err := NewKeyValueProvider(js, kvStoreName)
-	if err != nil {
-		return nil, err
+// Authenticator produces the connection options needed to authenticate
+// against a NATS server.
+type Authenticator interface {
+	Options() ([]nats.Option, error)
+}
+
+// AuthenticatorFunc adapts a plain function to the Authenticator interface.
+type AuthenticatorFunc func() ([]nats.Option, error)
+
+func (f AuthenticatorFunc) Options() ([]nats.Option, error) {
+	return f()
+}
+
+// UserPassword authenticates with a username and password.
+func UserPassword(username, password string) Authenticator {
+	return AuthenticatorFunc(func() ([]nats.Option, error) {
+		return []nats.Option{nats.UserInfo(username, password)}, nil
+	})
+}
+
+// Token authenticates with a static token.
+func Token(token string) Authenticator {
+	return AuthenticatorFunc(func() ([]nats.Option, error) {
+		return []nats.Option{nats.Token(token)}, nil
+	})
+}
+
+// TokenRefresher authenticates with a token obtained from refresh on every
+// (re)connect, so rotated tokens are picked up without rebuilding the provider.
+func TokenRefresher(refresh func() string) Authenticator {
+	return AuthenticatorFunc(func() ([]nats.Option, error) {
+		if refresh == nil {
+			return nil, fmt.Errorf("token refresher is nil")
+		}
+		return []nats.Option{nats.TokenHandler(refresh)}, nil
+	})
+}
+
+// NKeySeedFile authenticates with the NKey seed stored in seedFile.
+func NKeySeedFile(seedFile string) Authenticator {
+	return AuthenticatorFunc(func() ([]nats.Option, error) {
+		opt, err := nats.NkeyOptionFromSeed(seedFile)
+		if err != nil {
+			return nil, fmt.Errorf("load nkey seed %q: %w", seedFile, err)
+		}
+		return []nats.Option{opt}, nil
+	})
+}
+
+// CredsFile authenticates with a decentralized JWT .creds file.
+func CredsFile(credsFile string) Authenticator {
+	return AuthenticatorFunc(func() ([]nats.Option, error) {
+		if _, err := os.Stat(credsFile); err != nil {
+			return nil, fmt.Errorf("load creds %q: %w", credsFile, err)
+		}
+		return []nats.Option{nats.UserCredentials(credsFile)}, nil
+	})
+}
+
+// TLSAuth configures a TLS connection, optionally presenting a client
+// certificate for mutual TLS.
+type TLSAuth struct {
+	// CertFile and KeyFile hold the client certificate and its key. Leave both
+	// empty for server-only TLS.
+	CertFile string
+	KeyFile  string
+	// CAFiles are PEM bundles added to RootCAs.
+	CAFiles []string
+	// RootCAs verifies the server certificate. The system pool is used when
+	// both RootCAs and CAFiles are empty.
+	RootCAs *x509.CertPool
+	// ServerName overrides the name used to verify the server certificate.
+	ServerName string
+}
+
+func (a TLSAuth) Options() ([]nats.Option, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: a.ServerName,
 	}
-	objStore, err := NewObjectStoreProvider(js, objStoreName)
-	if err != nil {
-		return nil, err
+
+	if a.CertFile != "" || a.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(a.CertFile, a.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
 	}
-	stream := NewStreamProvider(js)
-	// config := NewConfigProvider(kv)
-
-	p := &NATSProvider{
-		name:        "nats",
-		version:     "1.0.0",
-		description: "NATS and JetStream Provider with Auth",
-		nc:          nc,
-		js:          js,
-		core:        core,
-		kv:          kv,
-		objStore:    objStore,
-		stream:      stream,
-		// config:   config,
+
+	pool := a.RootCAs
+	if len(a.CAFiles) > 0 {
+		if pool == nil {
+			pool = x509.NewCertPool()
+		} else {
+			pool = pool.Clone()
+		}
+		for _, file := range a.CAFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("read CA %q: %w", file, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA %q", file)
+			}
+		}
 	}
+	cfg.RootCAs = pool
 
-	return p, nil
+	return []nats.Option{nats.Secure(cfg)}, nil
+}
+
+// NewNATSProviderWithAuth connects with username and password and binds the
+// given buckets. streamName is unused and kept for compatibility.
+//
+// Deprecated: use NewNATSProvider with WithAuth, WithKVBucket and
+// WithObjectStore.
+func NewNATSProviderWithAuth(url, username, password, kvStoreName, objStoreName, streamName string) (Provider, error) {
+	return NewNATSProvider(url,
+		WithAuth(UserPassword(username, password)),
+		WithKVBucket(kvStoreName),
+		WithObjectStore(objStoreName),
+	)
 }
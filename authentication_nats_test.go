@@ -0,0 +1,243 @@
+package natsprovider
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nkeys"
+)
+
+func runAuthServer(t *testing.T, opts *server.Options) string {
+	t.Helper()
+
+	opts.Host = "127.0.0.1"
+	opts.Port = server.RANDOM_PORT
+	opts.JetStream = true
+	opts.StoreDir = t.TempDir()
+
+	ns, err := server.NewServer(opts)
+	if err != nil {
+		t.Fatalf("Error creating nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatal("Error starting nats server")
+	}
+	t.Cleanup(ns.Shutdown)
+
+	return ns.ClientURL()
+}
+
+func assertConnects(t *testing.T, url string, auth ...Authenticator) {
+	t.Helper()
+
+	p, err := NewNATSProvider(url, WithAuth(auth...))
+	if err != nil {
+		t.Fatalf("Error connecting with auth: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+	if err := p.Core().Publish(context.Background(), "auth.test", []byte("ok"), nil); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+}
+
+func TestUserPasswordAuth(t *testing.T) {
+	url := runAuthServer(t, &server.Options{Username: "user", Password: "pass"})
+
+	assertConnects(t, url, UserPassword("user", "pass"))
+
+	if p, err := NewNATSProvider(url, WithAuth(UserPassword("user", "wrong"))); err == nil {
+		_ = p.Close()
+		t.Fatal("Expected authorization error with wrong password")
+	}
+	p, err := NewNATSProviderWithAuth(url, "user", "pass", "auth_kv", "auth_objects", "")
+	if err != nil {
+		t.Fatalf("Error connecting with deprecated constructor: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Close() })
+}
+
+func TestTokenAuth(t *testing.T) {
+	url := runAuthServer(t, &server.Options{Authorization: "s3cr3t"})
+
+	assertConnects(t, url, Token("s3cr3t"))
+
+	calls := 0
+	assertConnects(t, url, TokenRefresher(func() string {
+		calls++
+		return "s3cr3t"
+	}))
+	if calls == 0 {
+		t.Fatal("Token refresher was never called")
+	}
+}
+
+func TestNKeySeedFileAuth(t *testing.T) {
+	kp, err := nkeys.CreateUser()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _ := kp.PublicKey()
+	seed, _ := kp.Seed()
+
+	seedFile := filepath.Join(t.TempDir(), "user.nk")
+	if err := os.WriteFile(seedFile, seed, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	url := runAuthServer(t, &server.Options{Nkeys: []*server.NkeyUser{{Nkey: pub}}})
+
+	assertConnects(t, url, NKeySeedFile(seedFile))
+}
+
+func TestCredsFileAuth(t *testing.T) {
+	okp, _ := nkeys.CreateOperator()
+	opub, _ := okp.PublicKey()
+	operator := jwt.NewOperatorClaims(opub)
+
+	akp, _ := nkeys.CreateAccount()
+	apub, _ := akp.PublicKey()
+	account := jwt.NewAccountClaims(apub)
+	account.Limits.JetStreamLimits = jwt.JetStreamLimits{MemoryStorage: -1, DiskStorage: -1}
+	accountJWT, err := account.Encode(okp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ukp, _ := nkeys.CreateUser()
+	upub, _ := ukp.PublicKey()
+	userJWT, err := jwt.NewUserClaims(upub).Encode(akp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	useed, _ := ukp.Seed()
+	creds, err := jwt.FormatUserConfig(userJWT, useed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credsFile := filepath.Join(t.TempDir(), "user.creds")
+	if err := os.WriteFile(credsFile, creds, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	skp, _ := nkeys.CreateAccount()
+	spub, _ := skp.PublicKey()
+	systemJWT, err := jwt.NewAccountClaims(spub).Encode(okp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resolver := &server.MemAccResolver{}
+	if err := resolver.Store(apub, accountJWT); err != nil {
+		t.Fatal(err)
+	}
+	if err := resolver.Store(spub, systemJWT); err != nil {
+		t.Fatal(err)
+	}
+	url := runAuthServer(t, &server.Options{
+		TrustedOperators: []*jwt.OperatorClaims{operator},
+		AccountResolver:  resolver,
+		SystemAccount:    spub,
+	})
+
+	assertConnects(t, url, CredsFile(credsFile))
+
+	if p, err := NewNATSProvider(url, WithAuth(CredsFile(filepath.Join(t.TempDir(), "missing.creds")))); err == nil {
+		_ = p.Close()
+		t.Fatal("Expected error for missing creds file")
+	}
+}
+
+func TestMutualTLSAuth(t *testing.T) {
+	dir := t.TempDir()
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", caDER)
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) (string, string) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			DNSNames:     []string{"localhost"},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		return writePEM(t, dir, name+".pem", "CERTIFICATE", der),
+			writePEM(t, dir, name+"-key.pem", "EC PRIVATE KEY", keyDER)
+	}
+	serverCert, serverKey := issue("server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert, clientKey := issue("client", 3, x509.ExtKeyUsageClientAuth)
+
+	tlsConfig, err := server.GenTLSConfig(&server.TLSConfigOpts{
+		CertFile: serverCert,
+		KeyFile:  serverKey,
+		CaFile:   caFile,
+		Verify:   true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := runAuthServer(t, &server.Options{
+		TLS:       true,
+		TLSVerify: true,
+		TLSConfig: tlsConfig,
+	})
+
+	assertConnects(t, url, TLSAuth{CertFile: clientCert, KeyFile: clientKey, CAFiles: []string{caFile}})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	assertConnects(t, url, TLSAuth{CertFile: clientCert, KeyFile: clientKey, RootCAs: pool})
+
+	if p, err := NewNATSProvider(url, WithAuth(TLSAuth{CAFiles: []string{caFile}})); err == nil {
+		_ = p.Close()
+		t.Fatal("Expected error connecting without a client certificate")
+	}
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
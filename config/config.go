@@ -1 +1,377 @@
+// Package config resolves configuration from layered sources such as
+// built-in defaults, a local file, environment variables and a NATS
+// key-value bucket. Keys are dotted paths like "app.db.host", the same keys
+// natsprovider.ConfigProvider and natsprovider.BindConfig use.
 package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	natsprovider "github.com/inovacc/nats-provider"
+)
+
+// ErrClosed is returned when using a closed Loader.
+var ErrClosed = errors.New("config: loader closed")
+
+// Source is one layer of configuration.
+type Source interface {
+	// Name identifies the layer in Sources and Events.
+	Name() string
+	// Load returns every key the layer defines.
+	Load() (map[string]string, error)
+}
+
+// WatchSource is a Source that reports its own changes until the returned
+// Unsubscriber stops it. deleted is set when the layer no longer defines key.
+type WatchSource interface {
+	Source
+	Watch(cb func(key, value string, deleted bool)) (natsprovider.Unsubscriber, error)
+}
+
+// SourceValue is the value one layer defines for a key.
+type SourceValue struct {
+	Source string
+	Value  string
+}
+
+// Event reports a change of the effective value of Key. Source is the layer
+// the new value comes from; it is empty when no layer defines Key anymore.
+type Event struct {
+	Key      string
+	Value    string
+	Previous string
+	Source   string
+	Deleted  bool
+}
+
+// Loader merges its sources into one set of effective values. Sources given
+// later take precedence, so the usual order is defaults, file, environment
+// and then the bucket shared by all instances.
+type Loader struct {
+	sources   []Source
+	layers    []map[string]string
+	effective map[string]SourceValue
+	watches   []natsprovider.Unsubscriber // of the WatchSources
+	loading   map[int][]change            // buffered while New loads the layer
+	watchers  map[*watcher]struct{}
+	lock      sync.Mutex
+	closed    bool
+}
+
+// change is a change reported by a WatchSource.
+type change struct {
+	key, value string
+	deleted    bool
+}
+
+// New loads every source in order of increasing precedence and follows
+// those that implement WatchSource. Their watches start before anything is
+// loaded, so a change made while New runs is applied rather than lost.
+func New(sources ...Source) (*Loader, error) {
+	l := &Loader{
+		sources:   sources,
+		layers:    make([]map[string]string, len(sources)),
+		effective: make(map[string]SourceValue),
+		loading:   make(map[int][]change),
+		watchers:  make(map[*watcher]struct{}),
+	}
+
+	for i, src := range sources {
+		w, ok := src.(WatchSource)
+		if !ok {
+			continue
+		}
+		l.lock.Lock()
+		l.loading[i] = nil
+		l.lock.Unlock()
+		sub, err := w.Watch(func(key, value string, deleted bool) { l.update(i, key, value, deleted) })
+		if err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("watch %s: %w", src.Name(), err)
+		}
+		l.lock.Lock()
+		l.watches = append(l.watches, sub)
+		l.lock.Unlock()
+	}
+
+	layers := make([]map[string]string, len(sources))
+	for i, src := range sources {
+		values, err := src.Load()
+		if err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("load %s: %w", src.Name(), err)
+		}
+		layers[i] = values
+	}
+
+	// Changes buffered during the load are at least as recent as it, so
+	// replaying them in order leaves every layer current.
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for i, values := range layers {
+		l.layers[i] = values
+		for _, c := range l.loading[i] {
+			l.apply(i, c)
+		}
+	}
+	clear(l.loading)
+	for _, key := range l.keys() {
+		if v, ok := l.resolve(key); ok {
+			l.effective[key] = v
+		}
+	}
+	return l, nil
+}
+
+// Get returns the effective value of key.
+func (l *Loader) Get(key string) (string, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	v, ok := l.effective[key]
+	return v.Value, ok
+}
+
+// All returns every effective value.
+func (l *Loader) All() map[string]string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	values := make(map[string]string, len(l.effective))
+	for key, v := range l.effective {
+		values[key] = v.Value
+	}
+	return values
+}
+
+// Sources explains how key was resolved: it returns the value of every layer
+// that defines key, highest precedence first, so the first entry is the
+// effective one.
+func (l *Loader) Sources(key string) []SourceValue {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var values []SourceValue
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		if value, ok := l.layers[i][key]; ok {
+			values = append(values, SourceValue{Source: l.sources[i].Name(), Value: value})
+		}
+	}
+	return values
+}
+
+// Watch calls cb whenever the effective value of a key changes, until the
+// returned Unsubscriber or Close stops it. Changes that are shadowed by a
+// layer of higher precedence, or that set a value again, are not reported.
+// Callbacks run in order on a goroutine of their own.
+func (l *Loader) Watch(cb func(Event)) (natsprovider.Unsubscriber, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return nil, ErrClosed
+	}
+	w := newWatcher(l, cb)
+	l.watchers[w] = struct{}{}
+	return w, nil
+}
+
+// Reload loads the sources that are not watched again, e.g. after the file
+// was edited, and reports the effective changes.
+func (l *Loader) Reload() error {
+	layers := make(map[int]map[string]string)
+	for i, src := range l.sources {
+		if _, ok := src.(WatchSource); ok {
+			continue // kept current by its watch
+		}
+		values, err := src.Load()
+		if err != nil {
+			return fmt.Errorf("load %s: %w", src.Name(), err)
+		}
+		layers[i] = values
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	for i, values := range layers {
+		l.layers[i] = values
+	}
+	for _, key := range l.keys() {
+		if e, ok := l.refresh(key); ok {
+			l.emit(e)
+		}
+	}
+	return nil
+}
+
+// Close stops following the watched sources and reporting changes. Sources
+// keep their own resources, such as the ConfigProvider behind KV.
+func (l *Loader) Close() error {
+	l.lock.Lock()
+	if l.closed {
+		l.lock.Unlock()
+		return nil
+	}
+	l.closed = true
+	for w := range l.watchers {
+		w.stop()
+		delete(l.watchers, w)
+	}
+	watches := l.watches
+	l.watches = nil
+	l.lock.Unlock()
+
+	var errs []error
+	for _, sub := range watches {
+		errs = append(errs, sub.Unsubscribe())
+	}
+	return errors.Join(errs...)
+}
+
+// update applies a change reported by the watched layer i.
+func (l *Loader) update(i int, key, value string, deleted bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return
+	}
+	c := change{key: key, value: value, deleted: deleted}
+	if pending, ok := l.loading[i]; ok {
+		l.loading[i] = append(pending, c)
+		return
+	}
+	l.apply(i, c)
+
+	if e, ok := l.refresh(key); ok {
+		l.emit(e)
+	}
+}
+
+// apply records c in layer i. Callers hold lock.
+func (l *Loader) apply(i int, c change) {
+	if l.layers[i] == nil {
+		l.layers[i] = make(map[string]string)
+	}
+	if c.deleted {
+		delete(l.layers[i], c.key)
+	} else {
+		l.layers[i][c.key] = c.value
+	}
+}
+
+// emit queues e for every watcher. Callers hold lock, which keeps events in
+// order; the callbacks run without it.
+func (l *Loader) emit(e Event) {
+	for w := range l.watchers {
+		w.push(e)
+	}
+}
+
+// refresh recomputes the effective value of key and returns the event for it
+// if it changed. Callers hold lock.
+func (l *Loader) refresh(key string) (Event, bool) {
+	prev, hadPrev := l.effective[key]
+	next, ok := l.resolve(key)
+	switch {
+	case !ok && !hadPrev:
+		return Event{}, false
+	case !ok:
+		delete(l.effective, key)
+		return Event{Key: key, Previous: prev.Value, Deleted: true}, true
+	}
+
+	l.effective[key] = next
+	if hadPrev && prev.Value == next.Value {
+		return Event{}, false
+	}
+	return Event{Key: key, Value: next.Value, Previous: prev.Value, Source: next.Source}, true
+}
+
+// resolve returns the value of key in the layer of highest precedence.
+func (l *Loader) resolve(key string) (SourceValue, bool) {
+	for i := len(l.layers) - 1; i >= 0; i-- {
+		if value, ok := l.layers[i][key]; ok {
+			return SourceValue{Source: l.sources[i].Name(), Value: value}, true
+		}
+	}
+	return SourceValue{}, false
+}
+
+// keys returns every key known to a layer or the effective set, sorted.
+func (l *Loader) keys() []string {
+	keys := make(map[string]struct{})
+	for _, layer := range l.layers {
+		for key := range layer {
+			keys[key] = struct{}{}
+		}
+	}
+	for key := range l.effective {
+		keys[key] = struct{}{}
+	}
+	return slices.Sorted(maps.Keys(keys))
+}
+
+// watcher queues events for one Watch callback, so a slow callback never
+// holds up the sources or the other callbacks, and may use the Loader.
+type watcher struct {
+	loader  *Loader
+	cb      func(Event)
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queue   []Event
+	stopped bool
+}
+
+func newWatcher(loader *Loader, cb func(Event)) *watcher {
+	w := &watcher{loader: loader, cb: cb}
+	w.cond = sync.NewCond(&w.lock)
+	go w.run()
+	return w
+}
+
+func (w *watcher) push(e Event) {
+	w.lock.Lock()
+	w.queue = append(w.queue, e)
+	w.lock.Unlock()
+	w.cond.Signal()
+}
+
+// Unsubscribe stops the callback and drops the events still queued. It may
+// be called from inside the callback, and more than once.
+func (w *watcher) Unsubscribe() error {
+	w.loader.lock.Lock()
+	delete(w.loader.watchers, w)
+	w.loader.lock.Unlock()
+
+	w.stop()
+	return nil
+}
+
+func (w *watcher) stop() {
+	w.lock.Lock()
+	w.stopped = true
+	w.lock.Unlock()
+	w.cond.Signal()
+}
+
+func (w *watcher) run() {
+	for {
+		w.lock.Lock()
+		for len(w.queue) == 0 && !w.stopped {
+			w.cond.Wait()
+		}
+		if w.stopped {
+			w.lock.Unlock()
+			return
+		}
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		w.lock.Unlock()
+
+		w.cb(e)
+	}
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	natsprovider "github.com/inovacc/nats-provider"
+)
+
+var testJS nats.JetStreamContext
+
+func TestMain(m *testing.M) {
+	storeDir, err := os.MkdirTemp("", "config")
+	if err != nil {
+		log.Fatalf("Error creating store dir: %v", err)
+	}
+
+	ns, err := server.NewServer(&server.Options{
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  storeDir,
+	})
+	if err != nil {
+		log.Fatalf("Error creating nats server: %v", err)
+	}
+
+	go ns.Start()
+
+	if !ns.ReadyForConnections(4 * time.Second) {
+		log.Fatal("Error starting nats server")
+	}
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	js, err := nc.JetStream()
+	if err != nil {
+		nc.Close()
+		log.Fatal(err)
+	}
+	testJS = js
+
+	code := m.Run()
+	nc.Close()
+	ns.Shutdown()
+	os.RemoveAll(storeDir)
+	os.Exit(code)
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Error writing %s: %v", name, err)
+	}
+	return path
+}
+
+func TestFileFormats(t *testing.T) {
+	want := map[string]string{
+		"app.name":           "billing",
+		"app.debug":          "true",
+		"app.db.port":        "5432",
+		"app.db.ratio":       "0.5",
+		"app.tags":           "a,b",
+		"app.servers.0.host": "one",
+		"app.servers.1.host": "two",
+	}
+	files := map[string]string{
+		"app.yaml": `
+app:
+  name: billing
+  debug: true
+  db: {port: 5432, ratio: 0.5}
+  tags: [a, b]
+  servers: [{host: one}, {host: two}]
+`,
+		"app.json": `{"app": {"name": "billing", "debug": true, "db": {"port": 5432, "ratio": 0.5},
+  "tags": ["a", "b"], "servers": [{"host": "one"}, {"host": "two"}]}}`,
+		"app.toml": `
+[app]
+name = "billing"
+debug = true
+tags = ["a", "b"]
+db = {port = 5432, ratio = 0.5}
+[[app.servers]]
+host = "one"
+[[app.servers]]
+host = "two"
+`,
+	}
+	for name, content := range files {
+		values, err := File(writeConfigFile(t, name, content)).Load()
+		if err != nil {
+			t.Fatalf("Error loading %s: %v", name, err)
+		}
+		if len(values) != len(want) {
+			t.Errorf("%s: expected %v, got %v", name, want, values)
+		}
+		for key, value := range want {
+			if values[key] != value {
+				t.Errorf("%s: expected %s=%q, got %q", name, key, value, values[key])
+			}
+		}
+	}
+
+	if _, err := File(writeConfigFile(t, "app.ini", "")).Load(); err == nil {
+		t.Fatal("Expected an error for an unsupported file type")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("CFGTEST_DB_HOST", "db.internal")
+	t.Setenv("CFGTEST_DB_POOL__SIZE", "10")
+	t.Setenv("CFGTESTX_IGNORED", "x")
+
+	values, err := Env("cfgtest").Load()
+	if err != nil {
+		t.Fatalf("Error loading env: %v", err)
+	}
+	want := map[string]string{"db.host": "db.internal", "db.pool_size": "10"}
+	if len(values) != len(want) || values["db.host"] != want["db.host"] || values["db.pool_size"] != want["db.pool_size"] {
+		t.Fatalf("Expected %v, got %v", want, values)
+	}
+}
+
+func TestLoader(t *testing.T) {
+	const bucket = "config_loader"
+	t.Cleanup(func() { _ = testJS.DeleteKeyValue(bucket) })
+
+	cfg, err := natsprovider.NewConfigProvider(testJS, bucket)
+	if err != nil {
+		t.Fatalf("Error creating config provider: %v", err)
+	}
+	defer cfg.Close()
+	if err := cfg.SetConfigValue("billing.log.level", "warn"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+
+	path := writeConfigFile(t, "app.yaml", "db:\n  host: file-host\n  port: 6432\n")
+	t.Setenv("LOADERTEST_DB_HOST", "env-host")
+
+	l, err := New(
+		Defaults(map[string]string{"db.host": "localhost", "db.port": "5432", "log.level": "info"}),
+		File(path),
+		Env("LOADERTEST"),
+		KV(cfg, "billing"),
+	)
+	if err != nil {
+		t.Fatalf("Error creating loader: %v", err)
+	}
+	defer l.Close()
+
+	for key, want := range map[string]string{"db.host": "env-host", "db.port": "6432", "log.level": "warn"} {
+		if got, ok := l.Get(key); !ok || got != want {
+			t.Errorf("Expected %s=%q, got %q", key, want, got)
+		}
+	}
+	wantSources := []SourceValue{{"env:LOADERTEST", "env-host"}, {"file:" + path, "file-host"}, {"defaults", "localhost"}}
+	if got := l.Sources("db.host"); !slices.Equal(got, wantSources) {
+		t.Fatalf("Expected sources %v, got %v", wantSources, got)
+	}
+
+	events := make(chan Event, 10)
+	if _, err := l.Watch(func(e Event) { events <- e }); err != nil {
+		t.Fatalf("Error watching loader: %v", err)
+	}
+	expect := func(want Event) {
+		t.Helper()
+		select {
+		case got := <-events:
+			if got != want {
+				t.Fatalf("Expected %+v, got %+v", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %+v", want)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case got := <-events:
+			t.Fatalf("Unexpected event %+v", got)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// The bucket wins over everything else and is followed live.
+	if err := cfg.SetConfigValue("billing.db.host", "nats-host"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	expect(Event{Key: "db.host", Value: "nats-host", Previous: "env-host", Source: "nats:billing"})
+
+	// Writing the same value again, or keys outside the prefix, changes nothing.
+	if err := cfg.SetConfigValue("billing.db.host", "nats-host"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	if err := cfg.SetConfigValue("other.db.host", "elsewhere"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	expectNone()
+
+	// A shadowed layer changing is not an effective change.
+	t.Setenv("LOADERTEST_DB_HOST", "env-host-2")
+	if err := l.Reload(); err != nil {
+		t.Fatalf("Error reloading: %v", err)
+	}
+	expectNone()
+
+	// Falling back to a lower layer is.
+	kv, err := testJS.KeyValue(bucket)
+	if err != nil {
+		t.Fatalf("Error opening bucket: %v", err)
+	}
+	if err := kv.Delete("billing.db.host"); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	expect(Event{Key: "db.host", Value: "env-host-2", Previous: "nats-host", Source: "env:LOADERTEST"})
+
+	if err := os.WriteFile(path, []byte("db:\n  host: file-host\n"), 0o644); err != nil {
+		t.Fatalf("Error rewriting file: %v", err)
+	}
+	if err := l.Reload(); err != nil {
+		t.Fatalf("Error reloading: %v", err)
+	}
+	expect(Event{Key: "db.port", Value: "5432", Previous: "6432", Source: "defaults"})
+	expectNone()
+
+	// A callback may read the loader, and stops once unsubscribed.
+	reads := make(chan string, 10)
+	sub, err := l.Watch(func(e Event) {
+		value, _ := l.Get(e.Key)
+		reads <- value
+	})
+	if err != nil {
+		t.Fatalf("Error watching loader: %v", err)
+	}
+
+	// A key set to "" in the bucket still overrides the lower layers.
+	if err := cfg.SetConfigValue("billing.log.level", ""); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	expect(Event{Key: "log.level", Value: "", Previous: "warn", Source: "nats:billing"})
+	select {
+	case value := <-reads:
+		if value != "" {
+			t.Fatalf("Expected the callback to read \"\", got %q", value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the callback")
+	}
+	if got := l.Sources("log.level"); len(got) == 0 || got[0] != (SourceValue{"nats:billing", ""}) {
+		t.Fatalf("Unexpected sources %v", got)
+	}
+
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Error unsubscribing: %v", err)
+	}
+	if err := cfg.SetConfigValue("billing.log.level", "debug"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	expect(Event{Key: "log.level", Value: "debug", Previous: "", Source: "nats:billing"})
+	select {
+	case value := <-reads:
+		t.Fatalf("Unexpected callback after unsubscribe: %q", value)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// racySource reports changes while it is loaded, like a bucket written
+// between reading it and watching it.
+type racySource struct {
+	cb func(key, value string, deleted bool)
+}
+
+func (s *racySource) Name() string { return "racy" }
+
+func (s *racySource) Load() (map[string]string, error) {
+	if s.cb != nil {
+		s.cb("changed", "new", false)
+		s.cb("deleted", "", true)
+	}
+	return map[string]string{"changed": "old", "deleted": "old", "kept": "old"}, nil
+}
+
+func (s *racySource) Watch(cb func(key, value string, deleted bool)) (natsprovider.Unsubscriber, error) {
+	s.cb = cb
+	return s, nil
+}
+
+func (s *racySource) Unsubscribe() error { return nil }
+
+func TestLoaderChangeDuringLoad(t *testing.T) {
+	l, err := New(&racySource{})
+	if err != nil {
+		t.Fatalf("Error creating loader: %v", err)
+	}
+	defer l.Close()
+
+	want := map[string]string{"changed": "new", "kept": "old"}
+	if got := l.All(); !maps.Equal(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+}
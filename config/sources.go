@@ -0,0 +1,234 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.yaml.in/yaml/v3"
+
+	natsprovider "github.com/inovacc/nats-provider"
+)
+
+type defaultsSource map[string]string
+
+// Defaults is a layer of built-in values.
+func Defaults(values map[string]string) Source {
+	return defaultsSource(maps.Clone(values))
+}
+
+func (s defaultsSource) Name() string { return "defaults" }
+
+func (s defaultsSource) Load() (map[string]string, error) {
+	return maps.Clone(s), nil
+}
+
+type fileSource struct {
+	path string
+}
+
+// File is a layer read from a YAML, JSON or TOML file, chosen by extension.
+// Nested tables become dotted keys, lists of scalars become comma-separated
+// values and other lists are indexed, as in "servers.0.host". The file must
+// exist; Reload reads it again.
+func File(path string) Source {
+	return &fileSource{path: path}
+}
+
+func (s *fileSource) Name() string { return "file:" + s.path }
+
+func (s *fileSource) Load() (map[string]string, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(s.path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err = dec.Decode(&doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", s.path, err)
+	}
+
+	values := make(map[string]string)
+	if err := flatten(values, "", doc); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", s.path, err)
+	}
+	return values, nil
+}
+
+// flatten stores the scalars of v under dotted keys below prefix.
+func flatten(values map[string]string, prefix string, v any) error {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if err := flatten(values, join(key), child); err != nil {
+				return err
+			}
+		}
+	case map[any]any:
+		for key, child := range v {
+			if err := flatten(values, join(fmt.Sprint(key)), child); err != nil {
+				return err
+			}
+		}
+	case []map[string]any: // TOML arrays of tables
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = item
+		}
+		return flatten(values, prefix, items)
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := scalar(item)
+			if !ok {
+				break
+			}
+			items = append(items, s)
+		}
+		if len(items) == len(v) {
+			values[prefix] = strings.Join(items, ",")
+			return nil
+		}
+		for i, item := range v {
+			if err := flatten(values, join(strconv.Itoa(i)), item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		s, ok := scalar(v)
+		if !ok {
+			return fmt.Errorf("%q: unsupported value %T", prefix, v)
+		}
+		values[prefix] = s
+	}
+	return nil
+}
+
+func scalar(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case bool:
+		return strconv.FormatBool(v), true
+	case int:
+		return strconv.Itoa(v), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case json.Number:
+		return v.String(), true
+	case time.Time:
+		return v.Format(time.RFC3339Nano), true
+	}
+	return "", false
+}
+
+type envSource struct {
+	prefix string
+}
+
+// Env is a layer of environment variables starting with prefix and "_".
+// The rest of the name is lower-cased and "_" separates key segments, so
+// APP_DB_HOST is "db.host" for prefix "APP"; "__" stands for a literal "_".
+// Variables are read on every load.
+func Env(prefix string) Source {
+	return &envSource{prefix: strings.ToUpper(prefix) + "_"}
+}
+
+func (s *envSource) Name() string { return "env:" + strings.TrimSuffix(s.prefix, "_") }
+
+func (s *envSource) Load() (map[string]string, error) {
+	values := make(map[string]string)
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		rest, ok := strings.CutPrefix(name, s.prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		parts := strings.Split(strings.ToLower(rest), "__")
+		for i, part := range parts {
+			parts[i] = strings.ReplaceAll(part, "_", ".")
+		}
+		values[strings.Join(parts, "_")] = value
+	}
+	return values, nil
+}
+
+type kvSource struct {
+	cfg    natsprovider.ConfigProvider
+	prefix string
+}
+
+// KV is a layer backed by a ConfigProvider, normally the one shared by every
+// instance through NATS, and follows its changes. Only keys below prefix are
+// used, with prefix removed; an empty prefix uses the whole bucket. The layer
+// is named "nats:" followed by prefix, or "nats" without one.
+func KV(cfg natsprovider.ConfigProvider, prefix string) WatchSource {
+	return &kvSource{cfg: cfg, prefix: prefix}
+}
+
+func (s *kvSource) Name() string {
+	if s.prefix == "" {
+		return "nats"
+	}
+	return "nats:" + s.prefix
+}
+
+func (s *kvSource) Load() (map[string]string, error) {
+	values, err := s.cfg.GetConfigValues(s.prefix)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := make(map[string]string, len(values))
+	for key, value := range values {
+		if key, ok := s.trim(key); ok {
+			trimmed[key] = value
+		}
+	}
+	return trimmed, nil
+}
+
+func (s *kvSource) Watch(cb func(key, value string, deleted bool)) (natsprovider.Unsubscriber, error) {
+	return s.cfg.WatchConfig(s.prefix, func(event natsprovider.ConfigEvent) {
+		if key, ok := s.trim(event.Key); ok {
+			cb(key, event.Value, event.Op != natsprovider.ConfigPut)
+		}
+	})
+}
+
+func (s *kvSource) trim(key string) (string, bool) {
+	if s.prefix == "" {
+		return key, true
+	}
+	return strings.CutPrefix(key, s.prefix+".")
+}
@@ -0,0 +1,498 @@
+package natsprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// configSyncTimeout bounds how long SetConfigValue waits for the watcher to
+// deliver the write back into the cache.
+const configSyncTimeout = 5 * time.Second
+
+// defaultConfigHistory is how many revisions per key a new config bucket
+// keeps for History and Rollback.
+const defaultConfigHistory = 16
+
+// ErrInvalidConfigKey is returned for keys that are empty or have empty or
+// wildcard segments.
+var ErrInvalidConfigKey = errors.New("natsprovider: invalid config key")
+
+// ConfigOption configures a ConfigProvider built by NewConfigProvider.
+type ConfigOption func(*configOptions)
+
+type configOptions struct {
+	history uint8
+	schema  *ConfigSchema
+	guard   bool
+	nc      *nats.Conn
+	subject string
+}
+
+// WithConfigHistory sets how many revisions per key the bucket keeps, at most
+// 64. It only applies when NewConfigProvider creates the bucket.
+func WithConfigHistory(revisions uint8) ConfigOption {
+	return func(o *configOptions) {
+		o.history = revisions
+	}
+}
+
+// WithConfigSchema makes SetConfigValue refuse values schema rejects.
+func WithConfigSchema(schema *ConfigSchema) ConfigOption {
+	return func(o *configOptions) {
+		o.schema = schema
+	}
+}
+
+// WithConfigGuard also checks the values other clients write to the bucket
+// against the schema. A rejected value never reaches the watchers and reads
+// keep returning the last good value. When subject is set, a ConfigRejection
+// is published there through nc for alerting; through WithConfigOptions nc
+// may be nil to use the provider's connection.
+func WithConfigGuard(nc *nats.Conn, subject string) ConfigOption {
+	return func(o *configOptions) {
+		o.guard = true
+		o.subject = subject
+		if nc != nil {
+			o.nc = nc
+		}
+	}
+}
+
+// withConfigConn sets the connection rejections are published through unless
+// WithConfigGuard named one.
+func withConfigConn(nc *nats.Conn) ConfigOption {
+	return func(o *configOptions) {
+		if o.nc == nil {
+			o.nc = nc
+		}
+	}
+}
+
+// ConfigOp is the kind of change reported by a ConfigEvent or recorded in a
+// ConfigRevision.
+type ConfigOp int
+
+const (
+	ConfigPut ConfigOp = iota
+	ConfigDelete
+	ConfigPurge
+)
+
+func (op ConfigOp) String() string {
+	switch op {
+	case ConfigPut:
+		return "put"
+	case ConfigDelete:
+		return "delete"
+	case ConfigPurge:
+		return "purge"
+	}
+	return fmt.Sprintf("ConfigOp(%d)", int(op))
+}
+
+func configOp(op nats.KeyValueOp) ConfigOp {
+	switch op {
+	case nats.KeyValueDelete:
+		return ConfigDelete
+	case nats.KeyValuePurge:
+		return ConfigPurge
+	}
+	return ConfigPut
+}
+
+// ConfigEvent is delivered to WatchConfig callbacks. Value is empty unless Op
+// is ConfigPut, so a deleted key is never mistaken for one set to "".
+type ConfigEvent struct {
+	Key   string
+	Value string
+	Op    ConfigOp
+}
+
+type configEntry struct {
+	value    string
+	revision uint64
+}
+
+// configProvider serves configuration from a key-value bucket. One watcher
+// over the whole bucket keeps an in-memory copy current, so reads never hit
+// the server and every WatchConfig shares the same subscription.
+type configProvider struct {
+	js        nats.JetStreamContext
+	store     nats.KeyValue
+	storeName string
+	opts      configOptions
+	watcher   nats.KeyWatcher
+	cache     map[string]configEntry
+	subs      map[*configSub]struct{}
+	revision  uint64        // last revision applied to the cache
+	changed   chan struct{} // closed and replaced whenever revision moves
+	lock      sync.Mutex
+	closed    bool
+}
+
+// NewConfigProvider opens the bucket named storeName, creating it when
+// missing, and loads its current values before returning. Keys are dotted
+// paths such as "app.db.host".
+func NewConfigProvider(js nats.JetStreamContext, storeName string, opts ...ConfigOption) (ConfigProvider, error) {
+	o := configOptions{history: defaultConfigHistory}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.guard && o.subject != "" && o.nc == nil {
+		return nil, errors.New("config guard needs a connection to publish rejections")
+	}
+
+	store, err := js.KeyValue(storeName)
+	if errors.Is(err, nats.ErrBucketNotFound) {
+		store, err = js.CreateKeyValue(&nats.KeyValueConfig{
+			Bucket:  storeName,
+			History: o.history,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	watcher, err := store.WatchAll()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &configProvider{
+		js:        js,
+		store:     store,
+		storeName: storeName,
+		opts:      o,
+		watcher:   watcher,
+		cache:     make(map[string]configEntry),
+		subs:      make(map[*configSub]struct{}),
+		changed:   make(chan struct{}),
+	}
+
+	// The watcher replays the latest value of every key, then sends nil.
+	for update := range watcher.Updates() {
+		if update == nil {
+			break
+		}
+		c.apply(update)
+	}
+	go func() {
+		for update := range watcher.Updates() {
+			if update != nil {
+				c.apply(update)
+			}
+		}
+	}()
+	return c, nil
+}
+
+// apply records update in the cache and hands it to the matching watchers.
+func (c *configProvider) apply(update nats.KeyValueEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	defer func() {
+		c.revision = max(c.revision, update.Revision())
+		close(c.changed)
+		c.changed = make(chan struct{})
+	}()
+
+	event := ConfigEvent{Key: update.Key(), Op: configOp(update.Operation())}
+	if event.Op == ConfigPut {
+		event.Value = string(update.Value())
+		if c.opts.guard && c.opts.schema != nil {
+			if err := c.opts.schema.Validate(event.Key, event.Value); err != nil {
+				c.reject(update, err)
+				return
+			}
+		}
+		c.cache[event.Key] = configEntry{value: event.Value, revision: update.Revision()}
+	} else {
+		delete(c.cache, event.Key)
+	}
+	for sub := range c.subs {
+		if configKeyMatches(sub.pattern, event.Key) {
+			sub.push(event)
+		}
+	}
+}
+
+// reject publishes a ConfigRejection for update when a subject is set.
+// Publishing is best effort; the value is dropped either way.
+func (c *configProvider) reject(update nats.KeyValueEntry, err error) {
+	if c.opts.subject == "" {
+		return
+	}
+	data, jsonErr := json.Marshal(ConfigRejection{
+		Bucket:   c.storeName,
+		Key:      update.Key(),
+		Value:    string(update.Value()),
+		Revision: update.Revision(),
+		Error:    err.Error(),
+		Time:     update.Created(),
+	})
+	if jsonErr == nil {
+		_ = c.opts.nc.Publish(c.opts.subject, data)
+	}
+}
+
+// GetConfigValue returns the cached value of key, or an error wrapping
+// nats.ErrKeyNotFound.
+func (c *configProvider) GetConfigValue(key string) (string, error) {
+	if err := validateConfigKey(key); err != nil {
+		return "", err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return "", ErrClosed
+	}
+	entry, ok := c.cache[key]
+	if !ok {
+		return "", fmt.Errorf("config %q: %w", key, nats.ErrKeyNotFound)
+	}
+	return entry.value, nil
+}
+
+func (c *configProvider) GetConfigValues(key string) (map[string]string, error) {
+	if key != "" {
+		if err := validateConfigPattern(key); err != nil {
+			return nil, err
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	values := make(map[string]string)
+	for k, entry := range c.cache {
+		if configKeyMatches(key, k) {
+			values[k] = entry.value
+		}
+	}
+	return values, nil
+}
+
+// SetConfigValue stores value under key and waits until the cache reflects
+// it, so a following GetConfigValue reads the new value. With a schema the
+// value is validated first and refused with ErrInvalidConfigValue.
+func (c *configProvider) SetConfigValue(key, value string) error {
+	if err := validateConfigKey(key); err != nil {
+		return err
+	}
+	if c.opts.schema != nil {
+		if err := c.opts.schema.Validate(key, value); err != nil {
+			return err
+		}
+	}
+
+	c.lock.Lock()
+	closed := c.closed
+	c.lock.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	revision, err := c.store.PutString(key, value)
+	if err != nil {
+		return err
+	}
+	c.waitRevision(revision)
+	return nil
+}
+
+// waitRevision blocks until the watcher applied revision, the provider is
+// closed or configSyncTimeout passes. The write is stored either way.
+func (c *configProvider) waitRevision(revision uint64) {
+	c.waitCache(func() bool { return c.revision >= revision })
+}
+
+// waitCache blocks until applied reports true, the provider is closed or
+// configSyncTimeout passes. applied is called with lock held.
+func (c *configProvider) waitCache(applied func() bool) {
+	timeout := time.NewTimer(configSyncTimeout)
+	defer timeout.Stop()
+
+	for {
+		c.lock.Lock()
+		done, changed := c.closed || applied(), c.changed
+		c.lock.Unlock()
+		if done {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-timeout.C:
+			return
+		}
+	}
+}
+
+// WatchConfig calls cb with the current value of every key matching key, then
+// with every change until the returned Unsubscriber or Close stops it. key
+// matches itself and all keys below it; "*" matches any single segment and
+// ">" all remaining ones, as in NATS subjects. An empty key watches the whole
+// bucket. Callbacks run in order on a goroutine of their own.
+func (c *configProvider) WatchConfig(key string, cb func(ConfigEvent)) (Unsubscriber, error) {
+	if key != "" {
+		if err := validateConfigPattern(key); err != nil {
+			return nil, err
+		}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+
+	sub := newConfigSub(c, key, cb)
+	keys := make([]string, 0, len(c.cache))
+	for k := range c.cache {
+		if configKeyMatches(key, k) {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		sub.push(ConfigEvent{Key: k, Value: c.cache[k].value, Op: ConfigPut})
+	}
+	c.subs[sub] = struct{}{}
+	return sub, nil
+}
+
+// Close stops the bucket watcher and every WatchConfig callback. Callbacks
+// already queued are dropped.
+func (c *configProvider) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for sub := range c.subs {
+		sub.stop()
+		delete(c.subs, sub)
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return c.watcher.Stop()
+}
+
+// configSub queues changes for one WatchConfig callback, so a slow callback
+// never holds up the bucket watcher or the other callbacks.
+type configSub struct {
+	provider *configProvider
+	pattern  string
+	cb       func(ConfigEvent)
+	lock     sync.Mutex
+	cond     *sync.Cond
+	queue    []ConfigEvent
+	stopped  bool
+}
+
+func newConfigSub(provider *configProvider, pattern string, cb func(ConfigEvent)) *configSub {
+	s := &configSub{provider: provider, pattern: pattern, cb: cb}
+	s.cond = sync.NewCond(&s.lock)
+	go s.run()
+	return s
+}
+
+func (s *configSub) push(event ConfigEvent) {
+	s.lock.Lock()
+	s.queue = append(s.queue, event)
+	s.lock.Unlock()
+	s.cond.Signal()
+}
+
+// Unsubscribe stops the callback. Changes already queued are dropped and a
+// callback already running is not waited for, so it may be called from
+// inside the callback, and more than once.
+func (s *configSub) Unsubscribe() error {
+	s.provider.lock.Lock()
+	delete(s.provider.subs, s)
+	s.provider.lock.Unlock()
+
+	s.stop()
+	return nil
+}
+
+func (s *configSub) stop() {
+	s.lock.Lock()
+	s.stopped = true
+	s.lock.Unlock()
+	s.cond.Signal()
+}
+
+func (s *configSub) run() {
+	for {
+		s.lock.Lock()
+		for len(s.queue) == 0 && !s.stopped {
+			s.cond.Wait()
+		}
+		if s.stopped {
+			s.lock.Unlock()
+			return
+		}
+		event := s.queue[0]
+		s.queue = s.queue[1:]
+		s.lock.Unlock()
+
+		s.cb(event)
+	}
+}
+
+// configKeyMatches reports whether key is pattern, lies below it or matches
+// its wildcards.
+func configKeyMatches(pattern, key string) bool {
+	if pattern == "" {
+		return true
+	}
+	patternParts, keyParts := strings.Split(pattern, "."), strings.Split(key, ".")
+	for i, part := range patternParts {
+		switch {
+		case part == ">":
+			return len(keyParts) > i
+		case i >= len(keyParts):
+			return false
+		case part != "*" && part != keyParts[i]:
+			return false
+		}
+	}
+	return true
+}
+
+func validateConfigKey(key string) error {
+	if err := validateConfigPattern(key); err != nil {
+		return err
+	}
+	if strings.ContainsAny(key, "*>") {
+		return fmt.Errorf("%w: %q", ErrInvalidConfigKey, key)
+	}
+	return nil
+}
+
+func validateConfigPattern(key string) error {
+	parts := strings.Split(key, ".")
+	for i, part := range parts {
+		if part == "" || (part == ">" && i != len(parts)-1) {
+			return fmt.Errorf("%w: %q", ErrInvalidConfigKey, key)
+		}
+	}
+	return nil
+}
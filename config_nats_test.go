@@ -0,0 +1,157 @@
+package natsprovider
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+type configChangeLog chan ConfigEvent
+
+func (l configChangeLog) cb(event ConfigEvent) { l <- event }
+
+func (l configChangeLog) expect(t *testing.T, key, value string) {
+	t.Helper()
+	l.expectEvent(t, ConfigEvent{Key: key, Value: value, Op: ConfigPut})
+}
+
+func (l configChangeLog) expectEvent(t *testing.T, want ConfigEvent) {
+	t.Helper()
+	select {
+	case got := <-l:
+		if got != want {
+			t.Fatalf("Expected %+v, got %+v", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %+v", want)
+	}
+}
+
+func (l configChangeLog) expectNone(t *testing.T) {
+	t.Helper()
+	select {
+	case got := <-l:
+		t.Fatalf("Unexpected change %+v", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestConfigProvider(t *testing.T) {
+	const bucket = "config_test"
+	t.Cleanup(func() { _ = testObj.js.DeleteKeyValue(bucket) })
+
+	kv, err := testObj.js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket})
+	if err != nil {
+		t.Fatalf("Error creating bucket: %v", err)
+	}
+	if _, err := kv.PutString("app.db.host", "localhost"); err != nil {
+		t.Fatalf("Error seeding bucket: %v", err)
+	}
+
+	cfg, err := NewConfigProvider(testObj.js, bucket)
+	if err != nil {
+		t.Fatalf("Error creating config provider: %v", err)
+	}
+	defer cfg.Close()
+
+	// Values stored before the provider existed are loaded up front.
+	if value, err := cfg.GetConfigValue("app.db.host"); err != nil || value != "localhost" {
+		t.Fatalf("Expected localhost, got %q, %v", value, err)
+	}
+	if _, err := cfg.GetConfigValue("app.db.port"); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+	if err := cfg.SetConfigValue("app..port", "1"); !errors.Is(err, ErrInvalidConfigKey) {
+		t.Fatalf("Expected ErrInvalidConfigKey, got %v", err)
+	}
+
+	// Writes are visible to reads right away.
+	if err := cfg.SetConfigValue("app.db.port", "5432"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	if value, err := cfg.GetConfigValue("app.db.port"); err != nil || value != "5432" {
+		t.Fatalf("Expected 5432, got %q, %v", value, err)
+	}
+
+	// A late subscriber gets the current state first, in key order.
+	db := make(configChangeLog, 10)
+	dbSub, err := cfg.WatchConfig("app.db", db.cb)
+	if err != nil {
+		t.Fatalf("Error watching config: %v", err)
+	}
+	db.expect(t, "app.db.host", "localhost")
+	db.expect(t, "app.db.port", "5432")
+
+	hosts := make(configChangeLog, 10)
+	if _, err := cfg.WatchConfig("*.*.host", hosts.cb); err != nil {
+		t.Fatalf("Error watching config: %v", err)
+	}
+	hosts.expect(t, "app.db.host", "localhost")
+
+	// Changes made elsewhere reach the matching watchers only.
+	if _, err := kv.PutString("app.db.host", "db.internal"); err != nil {
+		t.Fatalf("Error updating bucket: %v", err)
+	}
+	db.expect(t, "app.db.host", "db.internal")
+	hosts.expect(t, "app.db.host", "db.internal")
+
+	if err := cfg.SetConfigValue("app.cache.ttl", "1m"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	db.expectNone(t)
+	hosts.expectNone(t)
+
+	// A key set to "" is still a value; a delete is reported as such.
+	if err := cfg.SetConfigValue("app.db.port", ""); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	db.expect(t, "app.db.port", "")
+	if err := kv.Delete("app.db.port"); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	db.expectEvent(t, ConfigEvent{Key: "app.db.port", Op: ConfigDelete})
+
+	// An unsubscribed watch gets nothing more while the others go on.
+	if err := dbSub.Unsubscribe(); err != nil {
+		t.Fatalf("Error unsubscribing: %v", err)
+	}
+	if _, err := kv.PutString("app.db.host", "db2.internal"); err != nil {
+		t.Fatalf("Error updating bucket: %v", err)
+	}
+	hosts.expect(t, "app.db.host", "db2.internal")
+	db.expectNone(t)
+
+	if err := cfg.Close(); err != nil {
+		t.Fatalf("Error closing config provider: %v", err)
+	}
+	if _, err := cfg.GetConfigValue("app.db.host"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+	if _, err := cfg.WatchConfig("app", db.cb); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestConfigKeyMatches(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"", "app.db.host", true},
+		{"app", "app", true},
+		{"app", "app.db.host", true},
+		{"app", "apps.db", false},
+		{"app.db.host", "app.db", false},
+		{"app.*.host", "app.db.host", true},
+		{"app.*.host", "app.cache.ttl", false},
+		{"app.>", "app.db", true},
+		{"app.>", "app", false},
+	}
+	for _, tt := range tests {
+		if got := configKeyMatches(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("configKeyMatches(%q, %q) = %v, want %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package natsprovider
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeFor[time.Duration]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+)
+
+// ConfigBinding holds a struct decoded from the configuration under a prefix
+// and replaces it whenever one of those keys changes.
+type ConfigBinding[T any] struct {
+	prefix  string
+	current atomic.Pointer[T]
+	sub     Unsubscriber
+	lock    sync.Mutex
+	values  map[string]string
+	hooks   []func(old, new *T)
+	err     error
+	closed  bool
+}
+
+// BindConfig decodes the keys under prefix into a T and keeps it current
+// until Close or until cfg is closed. T must be a struct; each exported
+// field reads the key prefix.name, where name comes from the field's
+// `config:"name"` tag or is the lower-cased field name, and `config:"-"`
+// skips the field. Struct fields read the keys below their own name,
+// embedded structs without a tag share the prefix of their parent.
+//
+// A `default:"..."` tag supplies the value of a missing key, and
+// `config:"name,required"` makes a missing key without default an error.
+// Besides strings, bools and numbers, fields may be time.Durations,
+// encoding.TextUnmarshalers or slices of these given as comma-separated
+// lists. A key set to "" is present: it takes the place of the default and
+// satisfies required, and decodes like any other value.
+func BindConfig[T any](cfg ConfigProvider, prefix string) (*ConfigBinding[T], error) {
+	if reflect.TypeFor[T]().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("bind config: %v is not a struct", reflect.TypeFor[T]())
+	}
+
+	values, err := cfg.GetConfigValues(prefix)
+	if err != nil {
+		return nil, err
+	}
+	b := &ConfigBinding[T]{prefix: prefix, values: values}
+	v, err := b.decode()
+	if err != nil {
+		return nil, err
+	}
+	b.current.Store(v)
+
+	// The watch replays the values read above first; those are no-ops.
+	sub, err := cfg.WatchConfig(prefix, b.update)
+	if err != nil {
+		return nil, err
+	}
+	b.sub = sub
+	return b, nil
+}
+
+// Close stops following changes. Load keeps returning the last value and
+// OnChange hooks are no longer called.
+func (b *ConfigBinding[T]) Close() error {
+	b.lock.Lock()
+	b.closed = true
+	b.lock.Unlock()
+	return b.sub.Unsubscribe()
+}
+
+// Load returns the current value. It is shared between callers and must not
+// be modified.
+func (b *ConfigBinding[T]) Load() *T {
+	return b.current.Load()
+}
+
+// OnChange registers fn to be called after every reload with the previous and
+// the new value.
+func (b *ConfigBinding[T]) OnChange(fn func(old, new *T)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.hooks = append(b.hooks, fn)
+}
+
+// Err returns why the latest change could not be decoded, or nil if Load
+// reflects the current configuration. A failed reload keeps the last good
+// value.
+func (b *ConfigBinding[T]) Err() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.err
+}
+
+func (b *ConfigBinding[T]) update(event ConfigEvent) {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return
+	}
+	if current, ok := b.values[event.Key]; ok == (event.Op == ConfigPut) && current == event.Value {
+		b.lock.Unlock()
+		return
+	}
+	if event.Op == ConfigPut {
+		b.values[event.Key] = event.Value
+	} else {
+		delete(b.values, event.Key)
+	}
+
+	next, err := b.decode()
+	b.err = err
+	if err != nil {
+		b.lock.Unlock()
+		return
+	}
+	old := b.current.Swap(next)
+	hooks := b.hooks
+	b.lock.Unlock()
+
+	for _, hook := range hooks {
+		hook(old, next)
+	}
+}
+
+func (b *ConfigBinding[T]) decode() (*T, error) {
+	v := new(T)
+	if err := decodeConfigStruct(b.values, b.prefix, reflect.ValueOf(v).Elem()); err != nil {
+		return nil, fmt.Errorf("bind config %q: %w", b.prefix, err)
+	}
+	return v, nil
+}
+
+func decodeConfigStruct(values map[string]string, prefix string, v reflect.Value) error {
+	var errs []error
+	t := v.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			continue
+		}
+		tag := field.Tag.Get("config")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+
+		if name == "" && field.Anonymous && isConfigStruct(fv) {
+			errs = append(errs, decodeConfigStruct(values, prefix, fv))
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+
+		if isConfigStruct(fv) {
+			errs = append(errs, decodeConfigStruct(values, key, fv))
+			continue
+		}
+
+		if !isConfigValueType(field.Type) {
+			errs = append(errs, fmt.Errorf("%q: unsupported type %v", key, field.Type))
+			continue
+		}
+		raw, ok := values[key]
+		if !ok {
+			raw, ok = field.Tag.Lookup("default")
+		}
+		if !ok {
+			if opts == "required" {
+				errs = append(errs, fmt.Errorf("%q is required", key))
+			}
+			continue
+		}
+		if err := setConfigField(fv, raw); err != nil {
+			errs = append(errs, fmt.Errorf("%q: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isConfigStruct reports whether v is decoded field by field rather than
+// from a single value.
+func isConfigStruct(v reflect.Value) bool {
+	return v.Kind() == reflect.Struct && !v.Addr().Type().Implements(textUnmarshalerType)
+}
+
+// isConfigValueType reports whether setConfigField can decode into t.
+func isConfigValueType(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Slice && isConfigValueType(t.Elem())
+	}
+	return false
+}
+
+func setConfigField(v reflect.Value, raw string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(raw))
+	}
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(raw, 0, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		if raw = strings.TrimSpace(raw); raw != "" {
+			items = strings.Split(raw, ",")
+		}
+		slice := reflect.MakeSlice(v.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setConfigField(slice.Index(i), strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	}
+	return nil
+}
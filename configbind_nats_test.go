@@ -0,0 +1,175 @@
+package natsprovider
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+type testDBConfig struct {
+	Host    string        `config:"host,required"`
+	Port    int           `default:"5432"`
+	Timeout time.Duration `config:"timeout" default:"5s"`
+}
+
+type testLogging struct {
+	Level string `default:"info"`
+}
+
+type testAppConfig struct {
+	testLogging
+	Name     string     `config:"name"`
+	Debug    bool       `config:"debug"`
+	Ratio    float64    `config:"ratio"`
+	Tags     []string   `config:"tags"`
+	Ports    []uint16   `config:"ports" default:"80, 443"`
+	Addr     netip.Addr `config:"addr" default:"127.0.0.1"`
+	DB       testDBConfig
+	Internal string `config:"-"`
+}
+
+func TestBindConfig(t *testing.T) {
+	const bucket = "config_bind_test"
+	t.Cleanup(func() { _ = testObj.js.DeleteKeyValue(bucket) })
+
+	cfg, err := NewConfigProvider(testObj.js, bucket)
+	if err != nil {
+		t.Fatalf("Error creating config provider: %v", err)
+	}
+	defer cfg.Close()
+
+	if _, err := BindConfig[testAppConfig](cfg, "app"); err == nil {
+		t.Fatal("Expected an error for the missing required app.db.host")
+	}
+
+	for key, value := range map[string]string{
+		"app.name":     "billing",
+		"app.debug":    "true",
+		"app.ratio":    "0.25",
+		"app.tags":     "a, b,c",
+		"app.level":    "warn",
+		"app.db.host":  "db.internal",
+		"app.db.port":  "6432",
+		"app.internal": "ignored",
+	} {
+		if err := cfg.SetConfigValue(key, value); err != nil {
+			t.Fatalf("Error setting %s: %v", key, err)
+		}
+	}
+
+	binding, err := BindConfig[testAppConfig](cfg, "app")
+	if err != nil {
+		t.Fatalf("Error binding config: %v", err)
+	}
+	got := binding.Load()
+	want := testAppConfig{
+		testLogging: testLogging{Level: "warn"},
+		Name:        "billing",
+		Debug:       true,
+		Ratio:       0.25,
+		Tags:        []string{"a", "b", "c"},
+		Ports:       []uint16{80, 443},
+		Addr:        netip.MustParseAddr("127.0.0.1"),
+		DB:          testDBConfig{Host: "db.internal", Port: 6432, Timeout: 5 * time.Second},
+	}
+	if got.Name != want.Name || got.Debug != want.Debug || got.Ratio != want.Ratio || got.Level != want.Level ||
+		!slices.Equal(got.Tags, want.Tags) || !slices.Equal(got.Ports, want.Ports) || got.Addr != want.Addr ||
+		got.DB != want.DB || got.Internal != "" {
+		t.Fatalf("Expected %+v, got %+v", want, *got)
+	}
+
+	changes := make(chan [2]*testAppConfig, 1)
+	binding.OnChange(func(old, new *testAppConfig) {
+		changes <- [2]*testAppConfig{old, new}
+	})
+
+	if err := cfg.SetConfigValue("app.db.timeout", "30s"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	select {
+	case change := <-changes:
+		if change[0] != got || change[1].DB.Timeout != 30*time.Second || binding.Load() != change[1] {
+			t.Fatalf("Unexpected change %+v -> %+v", *change[0], *change[1])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for reload")
+	}
+
+	// A bad value keeps the last good config and is reported by Err.
+	current := binding.Load()
+	if err := cfg.SetConfigValue("app.db.port", "not-a-port"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for binding.Err() == nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if binding.Err() == nil {
+		t.Fatal("Expected a decode error")
+	}
+	if binding.Load() != current {
+		t.Fatal("Failed reload replaced the config")
+	}
+	select {
+	case change := <-changes:
+		t.Fatalf("Unexpected change to %+v", *change[1])
+	default:
+	}
+
+	if err := cfg.SetConfigValue("app.db.port", "7000"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	select {
+	case change := <-changes:
+		if change[1].DB.Port != 7000 || binding.Err() != nil {
+			t.Fatalf("Unexpected reload %+v, %v", *change[1], binding.Err())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for reload")
+	}
+
+	// A key set to "" is a value: it beats the default and satisfies
+	// required.
+	for _, key := range []string{"app.level", "app.db.host"} {
+		if err := cfg.SetConfigValue(key, ""); err != nil {
+			t.Fatalf("Error setting %s: %v", key, err)
+		}
+	}
+	empty, err := BindConfig[testAppConfig](cfg, "app")
+	if err != nil {
+		t.Fatalf("Error binding config with empty values: %v", err)
+	}
+	defer empty.Close()
+	if got := empty.Load(); got.Level != "" || got.DB.Host != "" {
+		t.Fatalf("Expected empty level and host, got %q and %q", got.Level, got.DB.Host)
+	}
+
+	// A closed binding keeps its last value and stops reloading.
+	for range 2 {
+		<-changes
+	}
+	if err := binding.Close(); err != nil {
+		t.Fatalf("Error closing binding: %v", err)
+	}
+	closed := binding.Load()
+	if err := cfg.SetConfigValue("app.name", "ledger"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if binding.Load() != closed {
+		t.Fatal("Closed binding was reloaded")
+	}
+	select {
+	case change := <-changes:
+		t.Fatalf("Unexpected change after close to %+v", *change[1])
+	default:
+	}
+
+	if _, err := BindConfig[string](cfg, "app"); err == nil {
+		t.Fatal("Expected an error binding a non-struct")
+	}
+	if _, err := BindConfig[struct{ C chan int }](cfg, "app"); err == nil {
+		t.Fatalf("Expected an unsupported type error, got %v", err)
+	}
+}
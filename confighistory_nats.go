@@ -0,0 +1,262 @@
+package natsprovider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// configSnapshotPrefix is where Snapshot stores tags in the object store.
+const configSnapshotPrefix = "config-snapshots/"
+
+// ConfigRevision is one entry in the history of a config key.
+type ConfigRevision struct {
+	Key      string
+	Value    string
+	Revision uint64
+	Created  time.Time
+	Op       ConfigOp
+}
+
+// ErrSnapshotBucket is returned by Restore for a snapshot taken from another
+// bucket, unless WithRestoreFromBucket names that bucket.
+var ErrSnapshotBucket = errors.New("natsprovider: snapshot taken from another bucket")
+
+// ConfigSnapshot is the content of a tag written by Snapshot.
+type ConfigSnapshot struct {
+	Tag     string            `json:"tag"`
+	Bucket  string            `json:"bucket"`
+	Prefix  string            `json:"prefix"`
+	Created time.Time         `json:"created"`
+	Values  map[string]string `json:"values"`
+}
+
+// ConfigRestore reports what Restore did. Keys are sorted; Failed holds the
+// keys that could not be brought back, which keep their current value.
+type ConfigRestore struct {
+	Written []string
+	Deleted []string
+	Failed  map[string]error
+}
+
+// RestoreOption configures Restore.
+type RestoreOption func(*restoreOptions)
+
+type restoreOptions struct {
+	bucket string
+}
+
+// WithRestoreFromBucket lets Restore apply a snapshot taken from bucket
+// instead of the provider's own bucket.
+func WithRestoreFromBucket(bucket string) RestoreOption {
+	return func(o *restoreOptions) {
+		o.bucket = bucket
+	}
+}
+
+func (c *configProvider) checkOpen() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// History returns the revisions of key the bucket still holds, oldest first.
+// How many are kept is set with WithConfigHistory when the bucket is created.
+func (c *configProvider) History(key string) ([]ConfigRevision, error) {
+	if err := validateConfigKey(key); err != nil {
+		return nil, err
+	}
+	if err := c.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	entries, err := c.store.History(key)
+	if err != nil {
+		return nil, fmt.Errorf("config %q: %w", key, err)
+	}
+	revisions := make([]ConfigRevision, len(entries))
+	for i, entry := range entries {
+		revisions[i] = ConfigRevision{
+			Key:      entry.Key(),
+			Value:    string(entry.Value()),
+			Revision: entry.Revision(),
+			Created:  entry.Created(),
+			Op:       configOp(entry.Operation()),
+		}
+	}
+	return revisions, nil
+}
+
+// GetAtRevision returns the value key had at revision. Revisions that
+// deleted key, or belong to another key, report nats.ErrKeyNotFound.
+func (c *configProvider) GetAtRevision(key string, revision uint64) (string, error) {
+	if err := validateConfigKey(key); err != nil {
+		return "", err
+	}
+	if err := c.checkOpen(); err != nil {
+		return "", err
+	}
+
+	entry, err := c.store.GetRevision(key, revision)
+	if err != nil {
+		return "", fmt.Errorf("config %q revision %d: %w", key, revision, err)
+	}
+	return string(entry.Value()), nil
+}
+
+// Rollback makes the value key had at revision current again by writing it
+// as a new revision, or deletes key if that revision deleted it. The value
+// goes through the schema like any other write.
+func (c *configProvider) Rollback(key string, revision uint64) error {
+	history, err := c.History(key)
+	if err != nil {
+		return err
+	}
+	for _, rev := range history {
+		if rev.Revision != revision {
+			continue
+		}
+		if rev.Op != ConfigPut {
+			return c.deleteConfigValue(key)
+		}
+		return c.SetConfigValue(key, rev.Value)
+	}
+	return fmt.Errorf("config %q revision %d: %w", key, revision, nats.ErrKeyNotFound)
+}
+
+// deleteConfigValue deletes key unless it changed since the cache saw it,
+// and waits until the cache reflects the delete.
+func (c *configProvider) deleteConfigValue(key string) error {
+	c.lock.Lock()
+	closed := c.closed
+	entry, ok := c.cache[key]
+	c.lock.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	if !ok {
+		return nil // already deleted
+	}
+
+	if err := c.store.Delete(key, nats.LastRevision(entry.revision)); err != nil {
+		return err
+	}
+	c.waitCache(func() bool {
+		current, ok := c.cache[key]
+		return !ok || current.revision > entry.revision
+	})
+	return nil
+}
+
+// Snapshot stores the current values of every key WatchConfig(prefix) would
+// report in objects under tag, replacing an older snapshot of that tag.
+func (c *configProvider) Snapshot(ctx context.Context, objects ObjectStoreProvider, prefix, tag string) (*ConfigSnapshot, error) {
+	if tag == "" {
+		return nil, errors.New("config snapshot needs a tag")
+	}
+	values, err := c.GetConfigValues(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &ConfigSnapshot{
+		Tag:     tag,
+		Bucket:  c.storeName,
+		Prefix:  prefix,
+		Created: time.Now().UTC(),
+		Values:  values,
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := objects.PutObject(ctx, configSnapshotPrefix+tag, data); err != nil {
+		return nil, fmt.Errorf("store snapshot %q: %w", tag, err)
+	}
+	return snapshot, nil
+}
+
+// Restore brings the keys under the prefix of the snapshot stored under tag
+// back to their snapshot values: changed keys are written, keys created
+// since are deleted and unchanged keys are left alone. Nothing is written
+// if a value fails the schema, or if the snapshot was taken from another
+// bucket and WithRestoreFromBucket does not name it. Otherwise every key is
+// attempted and the result lists those written, deleted and failed, even
+// when an error is returned.
+func (c *configProvider) Restore(ctx context.Context, objects ObjectStoreProvider, tag string, opts ...RestoreOption) (*ConfigRestore, error) {
+	o := restoreOptions{bucket: c.storeName}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	data, err := objects.GetObject(ctx, configSnapshotPrefix+tag)
+	if err != nil {
+		return nil, fmt.Errorf("load snapshot %q: %w", tag, err)
+	}
+	var snapshot ConfigSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("load snapshot %q: %w", tag, err)
+	}
+	if snapshot.Bucket != o.bucket {
+		return nil, fmt.Errorf("restore snapshot %q of bucket %q: %w", tag, snapshot.Bucket, ErrSnapshotBucket)
+	}
+
+	if c.opts.schema != nil {
+		var errs []error
+		for key, value := range snapshot.Values {
+			errs = append(errs, c.opts.schema.Validate(key, value))
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, fmt.Errorf("restore snapshot %q: %w", tag, err)
+		}
+	}
+
+	current, err := c.GetConfigValues(snapshot.Prefix)
+	if err != nil {
+		return nil, err
+	}
+	result := &ConfigRestore{Failed: make(map[string]error)}
+	var errs []error
+	fail := func(key string, err error) {
+		result.Failed[key] = err
+		errs = append(errs, fmt.Errorf("config %q: %w", key, err))
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(snapshot.Values)) {
+		if err := ctx.Err(); err != nil {
+			return result, errors.Join(append(errs, err)...)
+		}
+		value := snapshot.Values[key]
+		if old, ok := current[key]; ok && old == value {
+			continue
+		}
+		if err := c.SetConfigValue(key, value); err != nil {
+			fail(key, err)
+			continue
+		}
+		result.Written = append(result.Written, key)
+	}
+	for _, key := range slices.Sorted(maps.Keys(current)) {
+		if err := ctx.Err(); err != nil {
+			return result, errors.Join(append(errs, err)...)
+		}
+		if _, ok := snapshot.Values[key]; ok {
+			continue
+		}
+		if err := c.deleteConfigValue(key); err != nil {
+			fail(key, err)
+			continue
+		}
+		result.Deleted = append(result.Deleted, key)
+	}
+	return result, errors.Join(errs...)
+}
@@ -0,0 +1,198 @@
+package natsprovider
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestConfigHistory(t *testing.T) {
+	const bucket = "config_history_test"
+	t.Cleanup(func() { _ = testObj.js.DeleteKeyValue(bucket) })
+
+	schema := NewConfigSchema()
+	if err := schema.Register("app.db.port", ConfigIntRange(1, 65535)); err != nil {
+		t.Fatalf("Error registering validator: %v", err)
+	}
+	cfg, err := NewConfigProvider(testObj.js, bucket, WithConfigSchema(schema))
+	if err != nil {
+		t.Fatalf("Error creating config provider: %v", err)
+	}
+	defer cfg.Close()
+
+	for _, value := range []string{"5432", "6432", "7432"} {
+		if err := cfg.SetConfigValue("app.db.port", value); err != nil {
+			t.Fatalf("Error setting value: %v", err)
+		}
+	}
+	history, err := cfg.History("app.db.port")
+	if err != nil {
+		t.Fatalf("Error getting history: %v", err)
+	}
+	if len(history) != 3 || history[0].Value != "5432" || history[2].Value != "7432" ||
+		history[0].Op != ConfigPut || history[0].Created.IsZero() || history[0].Revision >= history[1].Revision {
+		t.Fatalf("Unexpected history %+v", history)
+	}
+	first := history[0].Revision
+
+	if value, err := cfg.GetAtRevision("app.db.port", first); err != nil || value != "5432" {
+		t.Fatalf("Expected 5432 at revision %d, got %q, %v", first, value, err)
+	}
+	if _, err := cfg.History("app.missing"); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound, got %v", err)
+	}
+
+	if err := cfg.Rollback("app.db.port", first); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+	if value, _ := cfg.GetConfigValue("app.db.port"); value != "5432" {
+		t.Fatalf("Expected 5432 after rollback, got %q", value)
+	}
+	if err := cfg.Rollback("app.db.port", 9999); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound for an unknown revision, got %v", err)
+	}
+
+	// Rolling back to a deletion deletes the key again.
+	kv, err := testObj.js.KeyValue(bucket)
+	if err != nil {
+		t.Fatalf("Error opening bucket: %v", err)
+	}
+	if err := kv.Delete("app.db.port"); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	if err := cfg.SetConfigValue("app.db.port", "8432"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	history, err = cfg.History("app.db.port")
+	if err != nil {
+		t.Fatalf("Error getting history: %v", err)
+	}
+	deleted := history[len(history)-2]
+	if deleted.Op != ConfigDelete {
+		t.Fatalf("Expected a delete revision, got %+v", deleted)
+	}
+	if _, err := cfg.GetAtRevision("app.db.port", deleted.Revision); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("Expected ErrKeyNotFound at a delete revision, got %v", err)
+	}
+	if err := cfg.Rollback("app.db.port", deleted.Revision); err != nil {
+		t.Fatalf("Error rolling back: %v", err)
+	}
+	if _, err := cfg.GetConfigValue("app.db.port"); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("Expected key to be deleted after rollback, got %v", err)
+	}
+
+	// A rollback still goes through the schema.
+	if _, err := kv.PutString("app.db.port", "0"); err != nil {
+		t.Fatalf("Error writing bucket: %v", err)
+	}
+	history, _ = cfg.History("app.db.port")
+	if err := cfg.Rollback("app.db.port", history[len(history)-1].Revision); !errors.Is(err, ErrInvalidConfigValue) {
+		t.Fatalf("Expected ErrInvalidConfigValue, got %v", err)
+	}
+}
+
+func TestConfigSnapshot(t *testing.T) {
+	const bucket, objects = "config_snapshot_test", "config_snapshot_objects"
+	t.Cleanup(func() {
+		_ = testObj.js.DeleteKeyValue(bucket)
+		_ = testObj.js.DeleteObjectStore(objects)
+	})
+
+	cfg, err := NewConfigProvider(testObj.js, bucket)
+	if err != nil {
+		t.Fatalf("Error creating config provider: %v", err)
+	}
+	defer cfg.Close()
+	store, err := NewObjectStoreProvider(testObj.js, objects)
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer store.Close()
+
+	good := map[string]string{"app.db.host": "db.internal", "app.db.port": "5432", "app.name": "billing"}
+	for key, value := range good {
+		if err := cfg.SetConfigValue(key, value); err != nil {
+			t.Fatalf("Error setting value: %v", err)
+		}
+	}
+	if err := cfg.SetConfigValue("other.key", "untouched"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+
+	snapshot, err := cfg.Snapshot(testObj.ctx, store, "app", "v1")
+	if err != nil {
+		t.Fatalf("Error taking snapshot: %v", err)
+	}
+	if snapshot.Tag != "v1" || snapshot.Bucket != bucket || !maps.Equal(snapshot.Values, good) {
+		t.Fatalf("Unexpected snapshot %+v", snapshot)
+	}
+
+	// A bad rollout changes, adds and removes keys.
+	if err := cfg.SetConfigValue("app.db.host", "wrong"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	if err := cfg.SetConfigValue("app.feature", "on"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	kv, err := testObj.js.KeyValue(bucket)
+	if err != nil {
+		t.Fatalf("Error opening bucket: %v", err)
+	}
+	if err := kv.Delete("app.name"); err != nil {
+		t.Fatalf("Error deleting key: %v", err)
+	}
+	if err := cfg.SetConfigValue("other.key", "changed"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+
+	result, err := cfg.Restore(testObj.ctx, store, "v1")
+	if err != nil {
+		t.Fatalf("Error restoring snapshot: %v", err)
+	}
+	if !slices.Equal(result.Written, []string{"app.db.host", "app.name"}) ||
+		!slices.Equal(result.Deleted, []string{"app.feature"}) || len(result.Failed) != 0 {
+		t.Fatalf("Unexpected restore result %+v", result)
+	}
+	values, err := cfg.GetConfigValues("app")
+	if err != nil {
+		t.Fatalf("Error getting values: %v", err)
+	}
+	if !maps.Equal(values, good) {
+		t.Fatalf("Expected %v after restore, got %v", good, values)
+	}
+	if value, _ := cfg.GetConfigValue("other.key"); value != "changed" {
+		t.Fatalf("Restore touched a key outside the prefix: %q", value)
+	}
+
+	if _, err := cfg.Restore(testObj.ctx, store, "missing"); err == nil {
+		t.Fatal("Expected an error restoring an unknown tag")
+	}
+
+	// A snapshot of another bucket is only applied when asked for.
+	const otherBucket = "config_snapshot_other"
+	t.Cleanup(func() { _ = testObj.js.DeleteKeyValue(otherBucket) })
+	other, err := NewConfigProvider(testObj.js, otherBucket)
+	if err != nil {
+		t.Fatalf("Error creating config provider: %v", err)
+	}
+	defer other.Close()
+	if err := other.SetConfigValue("app.extra", "kept?"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	if _, err := other.Restore(testObj.ctx, store, "v1"); !errors.Is(err, ErrSnapshotBucket) {
+		t.Fatalf("Expected ErrSnapshotBucket, got %v", err)
+	}
+	if value, _ := other.GetConfigValue("app.extra"); value != "kept?" {
+		t.Fatal("Refused restore changed the bucket")
+	}
+	result, err = other.Restore(testObj.ctx, store, "v1", WithRestoreFromBucket(bucket))
+	if err != nil {
+		t.Fatalf("Error restoring snapshot across buckets: %v", err)
+	}
+	if len(result.Written) != len(good) || !slices.Equal(result.Deleted, []string{"app.extra"}) {
+		t.Fatalf("Unexpected restore result %+v", result)
+	}
+}
@@ -0,0 +1,121 @@
+package natsprovider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrInvalidConfigValue is returned by SetConfigValue when a validator of the
+// provider's ConfigSchema rejects the value.
+var ErrInvalidConfigValue = errors.New("natsprovider: invalid config value")
+
+// ConfigValidator checks a value about to be stored under key.
+type ConfigValidator func(key, value string) error
+
+type configRule struct {
+	pattern   string
+	validator ConfigValidator
+}
+
+// ConfigSchema holds the validators a ConfigProvider applies to values. It
+// is safe to register validators while the schema is in use.
+type ConfigSchema struct {
+	lock  sync.RWMutex
+	rules []configRule
+}
+
+func NewConfigSchema() *ConfigSchema {
+	return &ConfigSchema{}
+}
+
+// Register validates the keys matching pattern with v. Patterns follow
+// WatchConfig: a key covers itself and the keys below it, "*" and ">" are
+// wildcards and "" covers every key.
+func (s *ConfigSchema) Register(pattern string, v ConfigValidator) error {
+	if pattern != "" {
+		if err := validateConfigPattern(pattern); err != nil {
+			return err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rules = append(s.rules, configRule{pattern: pattern, validator: v})
+	return nil
+}
+
+// Validate runs every validator registered for key and reports all their
+// errors, wrapped in ErrInvalidConfigValue.
+func (s *ConfigSchema) Validate(key, value string) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var errs []error
+	for _, rule := range s.rules {
+		if configKeyMatches(rule.pattern, key) {
+			errs = append(errs, rule.validator(key, value))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("%w %q: %w", ErrInvalidConfigValue, key, err)
+	}
+	return nil
+}
+
+// ConfigOneOf accepts only the given values.
+func ConfigOneOf(values ...string) ConfigValidator {
+	return func(_, value string) error {
+		if !slices.Contains(values, value) {
+			return fmt.Errorf("%q is not one of %q", value, values)
+		}
+		return nil
+	}
+}
+
+// ConfigIntRange accepts integers between minimum and maximum, both included.
+func ConfigIntRange(minimum, maximum int64) ConfigValidator {
+	return func(_, value string) error {
+		n, err := strconv.ParseInt(value, 0, 64)
+		if err != nil {
+			return err
+		}
+		if n < minimum || n > maximum {
+			return fmt.Errorf("%d is outside [%d, %d]", n, minimum, maximum)
+		}
+		return nil
+	}
+}
+
+// ConfigDuration accepts values time.ParseDuration understands.
+func ConfigDuration() ConfigValidator {
+	return func(_, value string) error {
+		_, err := time.ParseDuration(value)
+		return err
+	}
+}
+
+// ConfigMatch accepts values matching re.
+func ConfigMatch(re *regexp.Regexp) ConfigValidator {
+	return func(_, value string) error {
+		if !re.MatchString(value) {
+			return fmt.Errorf("%q does not match %s", value, re)
+		}
+		return nil
+	}
+}
+
+// ConfigRejection is published by a guarded ConfigProvider when a value
+// written to the bucket behind its back fails validation.
+type ConfigRejection struct {
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key"`
+	Value    string    `json:"value"`
+	Revision uint64    `json:"revision"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
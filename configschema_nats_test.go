@@ -0,0 +1,151 @@
+package natsprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func newTestSchema(t *testing.T) *ConfigSchema {
+	t.Helper()
+	schema := NewConfigSchema()
+	for pattern, v := range map[string]ConfigValidator{
+		"*.db.port":     ConfigIntRange(1, 65535),
+		"app.log.level": ConfigOneOf("debug", "info", "warn"),
+		"app.timeouts":  ConfigDuration(),
+		"app.name":      ConfigMatch(regexp.MustCompile(`^[a-z]+$`)),
+	} {
+		if err := schema.Register(pattern, v); err != nil {
+			t.Fatalf("Error registering %s: %v", pattern, err)
+		}
+	}
+	return schema
+}
+
+func TestConfigSchema(t *testing.T) {
+	schema := newTestSchema(t)
+
+	for _, tt := range []struct {
+		key, value string
+		valid      bool
+	}{
+		{"app.db.port", "5432", true},
+		{"app.db.port", "0", false},
+		{"app.db.port", "http", false},
+		{"app.log.level", "warn", true},
+		{"app.log.level", "trace", false},
+		{"app.timeouts.read", "5s", true},
+		{"app.timeouts.read", "5", false},
+		{"app.name", "Billing", false},
+		{"app.other", "anything", true},
+	} {
+		err := schema.Validate(tt.key, tt.value)
+		if tt.valid != (err == nil) || (err != nil && !errors.Is(err, ErrInvalidConfigValue)) {
+			t.Errorf("Validate(%q, %q) = %v, want valid %v", tt.key, tt.value, err, tt.valid)
+		}
+	}
+
+	if err := schema.Register("app..port", ConfigDuration()); !errors.Is(err, ErrInvalidConfigKey) {
+		t.Fatalf("Expected ErrInvalidConfigKey, got %v", err)
+	}
+}
+
+func TestConfigProviderSchema(t *testing.T) {
+	const bucket = "config_schema_test"
+	t.Cleanup(func() { _ = testObj.js.DeleteKeyValue(bucket) })
+
+	cfg, err := NewConfigProvider(testObj.js, bucket, WithConfigSchema(newTestSchema(t)))
+	if err != nil {
+		t.Fatalf("Error creating config provider: %v", err)
+	}
+	defer cfg.Close()
+
+	if err := cfg.SetConfigValue("app.db.port", "5432"); err != nil {
+		t.Fatalf("Error setting valid value: %v", err)
+	}
+	if err := cfg.SetConfigValue("app.db.port", "99999"); !errors.Is(err, ErrInvalidConfigValue) {
+		t.Fatalf("Expected ErrInvalidConfigValue, got %v", err)
+	}
+	if value, _ := cfg.GetConfigValue("app.db.port"); value != "5432" {
+		t.Fatalf("Rejected value was stored: %q", value)
+	}
+
+	if _, err := NewConfigProvider(testObj.js, bucket, WithConfigGuard(nil, "config.rejected")); err == nil {
+		t.Fatal("Expected an error for a guard without connection")
+	}
+}
+
+func TestConfigProviderGuard(t *testing.T) {
+	const bucket = "config_guard_test"
+	t.Cleanup(func() { _ = testObj.js.DeleteKeyValue(bucket) })
+
+	nc, err := nats.Connect(testObj.url)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	rejections, err := nc.SubscribeSync("config.rejected")
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+
+	cfg, err := NewConfigProvider(testObj.js, bucket,
+		WithConfigSchema(newTestSchema(t)),
+		WithConfigGuard(nc, "config.rejected"),
+	)
+	if err != nil {
+		t.Fatalf("Error creating config provider: %v", err)
+	}
+	defer cfg.Close()
+
+	if err := cfg.SetConfigValue("app.log.level", "info"); err != nil {
+		t.Fatalf("Error setting value: %v", err)
+	}
+	changes := make(configChangeLog, 10)
+	if _, err := cfg.WatchConfig("app", changes.cb); err != nil {
+		t.Fatalf("Error watching config: %v", err)
+	}
+	changes.expect(t, "app.log.level", "info")
+
+	// Another client bypasses the schema by writing to the bucket directly.
+	kv, err := testObj.js.KeyValue(bucket)
+	if err != nil {
+		t.Fatalf("Error opening bucket: %v", err)
+	}
+	revision, err := kv.PutString("app.log.level", "verbose")
+	if err != nil {
+		t.Fatalf("Error writing bucket: %v", err)
+	}
+
+	msg, err := rejections.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatalf("Expected a rejection event: %v", err)
+	}
+	var rejection ConfigRejection
+	if err := json.Unmarshal(msg.Data, &rejection); err != nil {
+		t.Fatalf("Error decoding rejection: %v", err)
+	}
+	if rejection.Bucket != bucket || rejection.Key != "app.log.level" || rejection.Value != "verbose" ||
+		rejection.Revision != revision || rejection.Error == "" {
+		t.Fatalf("Unexpected rejection %+v", rejection)
+	}
+
+	changes.expectNone(t)
+	if value, _ := cfg.GetConfigValue("app.log.level"); value != "info" {
+		t.Fatalf("Expected last good value info, got %q", value)
+	}
+
+	// Valid values from other clients still pass.
+	if _, err := kv.PutString("app.log.level", "debug"); err != nil {
+		t.Fatalf("Error writing bucket: %v", err)
+	}
+	changes.expect(t, "app.log.level", "debug")
+}
@@ -0,0 +1,442 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	defaultFetchBatch   = 1
+	defaultFetchMaxWait = 5 * time.Second
+	defaultMinBackoff   = 100 * time.Millisecond
+	defaultMaxBackoff   = 5 * time.Second
+)
+
+// ErrInProgress tells the consumer that the handler took ownership of the
+// message: the ack deadline is extended and the handler must settle it later
+// through StreamMessage.Ack, Nak or Term.
+var ErrInProgress = errors.New("natsprovider: message in progress")
+
+// StreamHandler processes a message fetched from a stream. Returning nil acks
+// the message; any other error naks it for immediate redelivery unless it
+// was built with NakWithDelay or Terminate, or is ErrInProgress.
+type StreamHandler func(ctx context.Context, msg *StreamMessage) error
+
+// StreamMessage is a message delivered by a stream consumer.
+type StreamMessage struct {
+	Message
+	Stream       string
+	Consumer     string
+	Sequence     uint64
+	NumDelivered uint64
+	NumPending   uint64
+	Timestamp    time.Time
+
+	msg *nats.Msg
+}
+
+func newStreamMessage(m *nats.Msg) *StreamMessage {
+	sm := &StreamMessage{Message: *toMessage(m), msg: m}
+	if meta, err := m.Metadata(); err == nil {
+		sm.Stream = meta.Stream
+		sm.Consumer = meta.Consumer
+		sm.Sequence = meta.Sequence.Stream
+		sm.NumDelivered = meta.NumDelivered
+		sm.NumPending = meta.NumPending
+		sm.Timestamp = meta.Timestamp
+	}
+	return sm
+}
+
+func (m *StreamMessage) Ack() error                         { return m.msg.Ack() }
+func (m *StreamMessage) Nak() error                         { return m.msg.Nak() }
+func (m *StreamMessage) NakWithDelay(d time.Duration) error { return m.msg.NakWithDelay(d) }
+func (m *StreamMessage) Term() error                        { return m.msg.Term() }
+func (m *StreamMessage) InProgress() error                  { return m.msg.InProgress() }
+
+type nakError struct {
+	delay time.Duration
+	err   error
+}
+
+func (e *nakError) Error() string { return "nak: " + errorString(e.err) }
+func (e *nakError) Unwrap() error { return e.err }
+
+type termError struct {
+	err error
+}
+
+func (e *termError) Error() string { return "term: " + errorString(e.err) }
+func (e *termError) Unwrap() error { return e.err }
+
+func errorString(err error) string {
+	if err == nil {
+		return "<nil>"
+	}
+	return err.Error()
+}
+
+// NakWithDelay is returned by a StreamHandler to have the message redelivered
+// after delay.
+func NakWithDelay(delay time.Duration, err error) error {
+	return &nakError{delay: delay, err: err}
+}
+
+// Terminate is returned by a StreamHandler to stop redelivery of a message
+// that can never be processed.
+func Terminate(err error) error {
+	return &termError{err: err}
+}
+
+// ConsumeOption configures the fetch loop started by SubscribeToStream.
+type ConsumeOption func(*consumeOptions)
+
+type consumeOptions struct {
+	batch      int
+	maxWait    time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+func newConsumeOptions(opts ...ConsumeOption) *consumeOptions {
+	o := &consumeOptions{
+		batch:      defaultFetchBatch,
+		maxWait:    defaultFetchMaxWait,
+		minBackoff: defaultMinBackoff,
+		maxBackoff: defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithFetchBatch sets how many messages are requested per fetch.
+func WithFetchBatch(batch int) ConsumeOption {
+	return func(o *consumeOptions) {
+		if batch > 0 {
+			o.batch = batch
+		}
+	}
+}
+
+// WithFetchMaxWait sets how long a single fetch waits for messages.
+func WithFetchMaxWait(maxWait time.Duration) ConsumeOption {
+	return func(o *consumeOptions) {
+		if maxWait > 0 {
+			o.maxWait = maxWait
+		}
+	}
+}
+
+// WithFetchBackoff sets the delay after a failed fetch, doubling from initial
+// up to limit while errors persist.
+func WithFetchBackoff(initial, limit time.Duration) ConsumeOption {
+	return func(o *consumeOptions) {
+		if initial > 0 {
+			o.minBackoff = initial
+		}
+		if limit >= o.minBackoff {
+			o.maxBackoff = limit
+		}
+	}
+}
+
+// streamConsumer is a pull subscription bound to a durable and fed by a
+// background fetch loop.
+type streamConsumer struct {
+	provider *streamProvider
+	sub      *nats.Subscription
+	handler  StreamHandler
+	opts     *consumeOptions
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error       // from removing sub, set before done is closed
+	handling atomic.Bool // set while the handler runs
+}
+
+func (c *streamConsumer) run(ctx context.Context) {
+	defer close(c.done)
+	defer c.release()
+
+	backoff := c.opts.minBackoff
+	for ctx.Err() == nil {
+		fetchCtx, cancel := context.WithTimeout(ctx, c.opts.maxWait)
+		msgs, err := c.sub.Fetch(c.opts.batch, nats.Context(fetchCtx))
+		cancel()
+
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil, errors.Is(err, context.DeadlineExceeded), errors.Is(err, nats.ErrTimeout):
+			backoff = c.opts.minBackoff
+		default:
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*2, c.opts.maxBackoff)
+			continue
+		}
+
+		for _, m := range msgs {
+			c.handling.Store(true)
+			err := c.handler(ctx, newStreamMessage(m))
+			c.handling.Store(false)
+			c.settle(m, err)
+		}
+	}
+}
+
+func (c *streamConsumer) settle(m *nats.Msg, err error) {
+	var nak *nakError
+	var term *termError
+	switch {
+	case err == nil:
+		_ = m.Ack()
+	case errors.Is(err, ErrInProgress):
+		_ = m.InProgress()
+	case errors.As(err, &term):
+		_ = m.Term()
+	case errors.As(err, &nak):
+		_ = m.NakWithDelay(nak.delay)
+	default:
+		_ = m.Nak()
+	}
+}
+
+// release removes the subscription and forgets the consumer. run calls it
+// on the way out, so cancelling the context cleans up like Unsubscribe.
+func (c *streamConsumer) release() {
+	c.provider.lock.Lock()
+	delete(c.provider.consumers, c)
+	c.provider.lock.Unlock()
+
+	c.err = c.sub.Unsubscribe()
+}
+
+// Unsubscribe stops the fetch loop once the current batch is handled and
+// removes the subscription. The durable consumer itself is kept. While the
+// handler is running, as when called from inside it, Unsubscribe only stops
+// the loop and returns nil without waiting; the loop cleans up once the
+// handler returns. It is safe to call again, or after the context was
+// cancelled; the loop has cleaned up by then.
+func (c *streamConsumer) Unsubscribe() error {
+	c.cancel()
+	if c.handling.Load() {
+		return nil
+	}
+	<-c.done
+	return c.err
+}
+
+// DeliverPolicy selects where a consumer starts in the stream.
+type DeliverPolicy int
+
+const (
+	DeliverAll DeliverPolicy = iota
+	DeliverLast
+	DeliverNew
+	DeliverByStartSequence
+	DeliverByStartTime
+	DeliverLastPerSubject
+)
+
+var deliverPolicies = map[DeliverPolicy]nats.DeliverPolicy{
+	DeliverAll:             nats.DeliverAllPolicy,
+	DeliverLast:            nats.DeliverLastPolicy,
+	DeliverNew:             nats.DeliverNewPolicy,
+	DeliverByStartSequence: nats.DeliverByStartSequencePolicy,
+	DeliverByStartTime:     nats.DeliverByStartTimePolicy,
+	DeliverLastPerSubject:  nats.DeliverLastPerSubjectPolicy,
+}
+
+// AckPolicy selects how a consumer expects messages to be acknowledged.
+type AckPolicy int
+
+const (
+	AckExplicit AckPolicy = iota
+	AckAll
+	AckNone
+)
+
+var ackPolicies = map[AckPolicy]nats.AckPolicy{
+	AckExplicit: nats.AckExplicitPolicy,
+	AckAll:      nats.AckAllPolicy,
+	AckNone:     nats.AckNonePolicy,
+}
+
+// ConsumerSpec describes a durable pull consumer. Zero values leave the
+// server defaults in place.
+type ConsumerSpec struct {
+	Durable     string
+	Description string
+	// FilterSubjects restricts the consumer to these subjects. More than one
+	// filter requires nats-server 2.10 or later.
+	FilterSubjects []string
+	DeliverPolicy  DeliverPolicy
+	// StartSequence is used with DeliverByStartSequence and StartTime with
+	// DeliverByStartTime.
+	StartSequence     uint64
+	StartTime         time.Time
+	AckPolicy         AckPolicy
+	AckWait           time.Duration
+	MaxDeliver        int
+	BackOff           []time.Duration
+	MaxAckPending     int
+	InactiveThreshold time.Duration
+}
+
+func (spec ConsumerSpec) config() (*nats.ConsumerConfig, error) {
+	deliver, ok := deliverPolicies[spec.DeliverPolicy]
+	if !ok {
+		return nil, fmt.Errorf("unknown deliver policy %d", spec.DeliverPolicy)
+	}
+	ack, ok := ackPolicies[spec.AckPolicy]
+	if !ok {
+		return nil, fmt.Errorf("unknown ack policy %d", spec.AckPolicy)
+	}
+
+	cfg := &nats.ConsumerConfig{
+		Durable:           spec.Durable,
+		Description:       spec.Description,
+		DeliverPolicy:     deliver,
+		OptStartSeq:       spec.StartSequence,
+		AckPolicy:         ack,
+		AckWait:           spec.AckWait,
+		MaxDeliver:        spec.MaxDeliver,
+		BackOff:           spec.BackOff,
+		MaxAckPending:     spec.MaxAckPending,
+		InactiveThreshold: spec.InactiveThreshold,
+	}
+	if !spec.StartTime.IsZero() {
+		startTime := spec.StartTime
+		cfg.OptStartTime = &startTime
+	}
+	if len(spec.FilterSubjects) == 1 {
+		cfg.FilterSubject = spec.FilterSubjects[0]
+	} else {
+		cfg.FilterSubjects = spec.FilterSubjects
+	}
+	return cfg, nil
+}
+
+func consumerSpecFromConfig(cfg nats.ConsumerConfig) ConsumerSpec {
+	spec := ConsumerSpec{
+		Durable:           cfg.Durable,
+		Description:       cfg.Description,
+		FilterSubjects:    cfg.FilterSubjects,
+		StartSequence:     cfg.OptStartSeq,
+		AckWait:           cfg.AckWait,
+		MaxDeliver:        cfg.MaxDeliver,
+		BackOff:           cfg.BackOff,
+		MaxAckPending:     cfg.MaxAckPending,
+		InactiveThreshold: cfg.InactiveThreshold,
+	}
+	if cfg.FilterSubject != "" {
+		spec.FilterSubjects = []string{cfg.FilterSubject}
+	}
+	if cfg.OptStartTime != nil {
+		spec.StartTime = *cfg.OptStartTime
+	}
+	for policy, natsPolicy := range deliverPolicies {
+		if natsPolicy == cfg.DeliverPolicy {
+			spec.DeliverPolicy = policy
+		}
+	}
+	for policy, natsPolicy := range ackPolicies {
+		if natsPolicy == cfg.AckPolicy {
+			spec.AckPolicy = policy
+		}
+	}
+	return spec
+}
+
+// ConsumerInfo reports the state of a consumer.
+type ConsumerInfo struct {
+	Stream  string
+	Name    string
+	Created time.Time
+	Spec    ConsumerSpec
+	// Delivered and AckFloor are the last delivered and last fully
+	// acknowledged stream sequences.
+	Delivered      uint64
+	AckFloor       uint64
+	NumPending     uint64
+	NumAckPending  int
+	NumRedelivered int
+	NumWaiting     int
+}
+
+func newConsumerInfo(info *nats.ConsumerInfo) *ConsumerInfo {
+	return &ConsumerInfo{
+		Stream:         info.Stream,
+		Name:           info.Name,
+		Created:        info.Created,
+		Spec:           consumerSpecFromConfig(info.Config),
+		Delivered:      info.Delivered.Stream,
+		AckFloor:       info.AckFloor.Stream,
+		NumPending:     info.NumPending,
+		NumAckPending:  info.NumAckPending,
+		NumRedelivered: info.NumRedelivered,
+		NumWaiting:     info.NumWaiting,
+	}
+}
+
+func (s *streamProvider) AddConsumer(ctx context.Context, stream string, spec ConsumerSpec) (*ConsumerInfo, error) {
+	cfg, err := spec.config()
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.js.AddConsumer(stream, cfg, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newConsumerInfo(info), nil
+}
+
+func (s *streamProvider) UpdateConsumer(ctx context.Context, stream string, spec ConsumerSpec) (*ConsumerInfo, error) {
+	cfg, err := spec.config()
+	if err != nil {
+		return nil, err
+	}
+	info, err := s.js.UpdateConsumer(stream, cfg, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newConsumerInfo(info), nil
+}
+
+func (s *streamProvider) ConsumerInfo(ctx context.Context, stream, name string) (*ConsumerInfo, error) {
+	info, err := s.js.ConsumerInfo(stream, name, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newConsumerInfo(info), nil
+}
+
+func (s *streamProvider) ListConsumers(ctx context.Context, stream string) ([]*ConsumerInfo, error) {
+	// Consumers swallows lookup errors, so check the stream exists first.
+	if _, err := s.js.StreamInfo(stream, nats.Context(ctx)); err != nil {
+		return nil, err
+	}
+
+	var consumers []*ConsumerInfo
+	for info := range s.js.Consumers(stream, nats.Context(ctx)) {
+		consumers = append(consumers, newConsumerInfo(info))
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return consumers, nil
+}
+
+func (s *streamProvider) DeleteConsumer(ctx context.Context, stream, name string) error {
+	return s.js.DeleteConsumer(stream, name, nats.Context(ctx))
+}
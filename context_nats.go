@@ -0,0 +1,60 @@
+package natsprovider
+
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+type headersKey struct{}
+
+// ContextWithHeaders returns a copy of ctx carrying headers that are added to
+// every message published with it, such as trace propagation headers.
+// Headers passed explicitly to a publish call take precedence.
+func ContextWithHeaders(ctx context.Context, headers map[string]string) context.Context {
+	merged := make(map[string]string, len(headers))
+	for k, v := range HeadersFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range headers {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, headersKey{}, merged)
+}
+
+// HeadersFromContext returns the headers attached with ContextWithHeaders.
+func HeadersFromContext(ctx context.Context) map[string]string {
+	headers, _ := ctx.Value(headersKey{}).(map[string]string)
+	return headers
+}
+
+func newMsg(ctx context.Context, subject string, data []byte, headers map[string]string) *nats.Msg {
+	m := &nats.Msg{Subject: subject, Data: data, Header: nats.Header{}}
+	for k, v := range HeadersFromContext(ctx) {
+		m.Header.Set(k, v)
+	}
+	for k, v := range headers {
+		m.Header.Set(k, v)
+	}
+	return m
+}
+
+// ctxSubscription unsubscribes automatically once its context is done.
+type ctxSubscription struct {
+	sub  *nats.Subscription
+	stop func() bool
+}
+
+func bindSubscription(ctx context.Context, sub *nats.Subscription) Unsubscriber {
+	return &ctxSubscription{
+		sub: sub,
+		stop: context.AfterFunc(ctx, func() {
+			_ = sub.Unsubscribe()
+		}),
+	}
+}
+
+func (s *ctxSubscription) Unsubscribe() error {
+	s.stop()
+	return s.sub.Unsubscribe()
+}
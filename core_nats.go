@@ -2,7 +2,6 @@ package natsprovider
 
 import (
 	"context"
-	"time"
 
 	"github.com/inovacc/nats-provider/utils"
 	"github.com/nats-io/nats.go"
@@ -12,55 +11,60 @@ type coreProvider struct {
 	nc *nats.Conn
 }
 
-func (c *coreProvider) Publish(subject string, msg []byte, headers map[string]string) error {
-	m := &nats.Msg{Subject: subject, Data: msg, Header: nats.Header{}}
-	for k, v := range headers {
-		m.Header.Set(k, v)
+func NewCoreProvider(nc *nats.Conn) CoreProvider {
+	return &coreProvider{nc: nc}
+}
+
+// Publish only hands msg to the connection's write buffer, so ctx is checked
+// once up front and cannot cancel the publish after that.
+func (c *coreProvider) Publish(ctx context.Context, subject string, msg []byte, headers map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
-	return c.nc.PublishMsg(m)
+	return c.nc.PublishMsg(newMsg(ctx, subject, msg, headers))
 }
 
-func (c *coreProvider) Subscribe(subject string, handler MsgHandler) (Unsubscriber, error) {
+// Subscribe and QueueSubscribe register the interest without a round trip;
+// ctx bounds the subscription's lifetime instead of the call.
+func (c *coreProvider) Subscribe(ctx context.Context, subject string, handler MsgHandler) (Unsubscriber, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	sub, err := c.nc.Subscribe(subject, func(m *nats.Msg) {
-		handler(&Message{
-			Subject: m.Subject,
-			Reply:   m.Reply,
-			Data:    m.Data,
-			Headers: utils.HeaderMap(m.Header),
-		})
+		handler(toMessage(m))
 	})
 	if err != nil {
 		return nil, err
 	}
-	return sub, nil
+	return bindSubscription(ctx, sub), nil
 }
 
-func (c *coreProvider) QueueSubscribe(subject, queue string, handler MsgHandler) (Unsubscriber, error) {
+func (c *coreProvider) QueueSubscribe(ctx context.Context, subject, queue string, handler MsgHandler) (Unsubscriber, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	sub, err := c.nc.QueueSubscribe(subject, queue, func(m *nats.Msg) {
-		handler(&Message{
-			Subject: m.Subject,
-			Reply:   m.Reply,
-			Data:    m.Data,
-			Headers: utils.HeaderMap(m.Header),
-		})
+		handler(toMessage(m))
 	})
 	if err != nil {
 		return nil, err
 	}
-	return sub, nil
+	return bindSubscription(ctx, sub), nil
 }
 
-func (c *coreProvider) Request(subject string, msg []byte, timeoutMs int) (*Message, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeoutMs)*time.Millisecond)
-	defer cancel()
-	resp, err := c.nc.RequestWithContext(ctx, subject, msg)
+func (c *coreProvider) Request(ctx context.Context, subject string, msg []byte) (*Message, error) {
+	resp, err := c.nc.RequestMsgWithContext(ctx, newMsg(ctx, subject, msg, nil))
 	if err != nil {
 		return nil, err
 	}
+	return toMessage(resp), nil
+}
+
+func toMessage(m *nats.Msg) *Message {
 	return &Message{
-		Subject: resp.Subject,
-		Reply:   resp.Reply,
-		Data:    resp.Data,
-		Headers: utils.HeaderMap(resp.Header),
-	}, nil
+		Subject: m.Subject,
+		Reply:   m.Reply,
+		Data:    m.Data,
+		Headers: utils.HeaderMap(m.Header),
+	}
 }
@@ -0,0 +1,53 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestCoreProviderContext(t *testing.T) {
+	nc, err := nats.Connect(testObj.url)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	core := NewCoreProvider(nc)
+
+	subCtx, cancelSub := context.WithCancel(testObj.ctx)
+	if _, err := core.Subscribe(subCtx, "core.echo", func(msg *Message) {
+		_ = nc.Publish(msg.Reply, []byte(msg.Headers["Trace-Id"]))
+	}); err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ContextWithHeaders(testObj.ctx, map[string]string{"Trace-Id": "abc"}), time.Second)
+	defer cancel()
+
+	resp, err := core.Request(ctx, "core.echo", nil)
+	if err != nil {
+		t.Fatalf("Error requesting: %v", err)
+	}
+	if string(resp.Data) != "abc" {
+		t.Fatalf("Expected propagated trace header, got %q", resp.Data)
+	}
+
+	cancelSub()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(testObj.ctx, 200*time.Millisecond)
+	defer cancel()
+	if _, err := core.Request(ctx, "core.echo", nil); !errors.Is(err, nats.ErrNoResponders) {
+		t.Fatalf("Expected no responders after context cancel, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(testObj.ctx)
+	cancel()
+	if err := core.Publish(cancelled, "core.echo", nil, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -5,91 +5,408 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
+const (
+	// OffsetHeader carries the byte offset of the first byte of a chunk.
+	OffsetHeader = "Jetfile-Offset"
+
+	defaultChunkSize = 128 * 1024
+	scanTimeout      = 5 * time.Second
+)
+
+var (
+	// ErrConcurrentWrite is returned by Write when another writer appended to
+	// the file since it was last indexed.
+	ErrConcurrentWrite = errors.New("jetfile: file was appended to by another writer")
+	// ErrClosed is returned when using a closed JetFile.
+	ErrClosed = errors.New("jetfile: file already closed")
+)
+
+// Option configures a JetFile opened with OpenJetFile.
+type Option func(*JetFile)
+
+// WithChunkSize sets the largest message Write publishes. It must stay below
+// the server's max payload.
+func WithChunkSize(size int) Option {
+	return func(f *JetFile) {
+		f.chunkSize = size
+	}
+}
+
+// chunk locates the bytes [offset, offset+size) of the file.
+type chunk struct {
+	offset int64
+	size   int64
+	seq    uint64
+}
+
+// JetFile is a byte-addressable append-only file stored as the messages of a
+// single subject in a stream. Every message is a chunk of the file whose
+// offset is kept in the OffsetHeader header; an in-memory index maps byte
+// offsets to stream sequences.
+//
+// Writes always append and leave the read offset untouched. Reads past the
+// indexed end look for chunks appended by other writers first, so a reader can
+// follow a growing file.
 type JetFile struct {
-	js       nats.JetStreamContext
-	stream   string
-	subject  []string
-	readSeq  uint64 // sequence index for reads
-	readLast uint64 // upper bound for reading
+	js        nats.JetStreamContext
+	stream    string
+	subject   string
+	chunkSize int
+
+	lock   sync.Mutex
+	chunks []chunk
+	size   int64
+	offset int64
+	cache  *nats.RawStreamMsg // last chunk read
+	closed bool
+}
+
+// OpenJetFile opens the file stored under subject in stream, indexing the
+// chunks already written. The stream must exist and capture subject.
+func OpenJetFile(js nats.JetStreamContext, stream, subject string, opts ...Option) (*JetFile, error) {
+	f := &JetFile{
+		js:        js,
+		stream:    stream,
+		subject:   subject,
+		chunkSize: defaultChunkSize,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if f.chunkSize <= 0 {
+		return nil, fmt.Errorf("jetfile: chunk size must be positive, got %d", f.chunkSize)
+	}
+	if _, err := js.StreamInfo(stream); err != nil {
+		return nil, fmt.Errorf("stream info: %w", err)
+	}
+	if err := f.refresh(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Size returns the indexed size of the file in bytes.
+func (f *JetFile) Size() int64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.size
 }
 
-func OpenJetFile(js nats.JetStreamContext, cfg *nats.StreamConfig) (*JetFile, error) {
-	return &JetFile{
-		js:      js,
-		stream:  cfg.Name,
-		subject: cfg.Subjects,
-	}, nil
+// refresh indexes the chunks appended since the last indexed one.
+func (f *JetFile) refresh() error {
+	last, err := f.js.GetLastMsg(f.stream, f.subject)
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("get last msg: %w", err)
+	}
+
+	var from uint64 = 1
+	if n := len(f.chunks); n > 0 {
+		if f.chunks[n-1].seq >= last.Sequence {
+			return nil
+		}
+		from = f.chunks[n-1].seq + 1
+	}
+
+	sub, err := f.js.SubscribeSync(f.subject,
+		nats.OrderedConsumer(),
+		nats.HeadersOnly(),
+		nats.StartSequence(from),
+		nats.BindStream(f.stream),
+	)
+	if err != nil {
+		return fmt.Errorf("subscribe: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		msg, err := sub.NextMsg(scanTimeout)
+		if err != nil {
+			return fmt.Errorf("index chunks: %w", err)
+		}
+		meta, err := msg.Metadata()
+		if err != nil {
+			return fmt.Errorf("index chunks: %w", err)
+		}
+		if err := f.index(meta.Sequence.Stream, msg.Header); err != nil {
+			return err
+		}
+		if meta.Sequence.Stream >= last.Sequence {
+			return nil
+		}
+	}
 }
 
-func (f *JetFile) Write(p []byte) (n int, err error) {
-	ack, err := f.js.Publish(f.subject[0], p)
+// index appends the chunk described by a headers-only message to the index.
+func (f *JetFile) index(seq uint64, header nats.Header) error {
+	size, err := strconv.ParseInt(header.Get(nats.MsgSize), 10, 64)
 	if err != nil {
-		return 0, fmt.Errorf("publish: %w", err)
+		return fmt.Errorf("chunk %d: bad size: %w", seq, err)
+	}
+	offset := f.size
+	if h := header.Get(OffsetHeader); h != "" {
+		if offset, err = strconv.ParseInt(h, 10, 64); err != nil {
+			return fmt.Errorf("chunk %d: bad offset: %w", seq, err)
+		}
+	}
+	if offset != f.size {
+		return fmt.Errorf("chunk %d: offset %d does not follow file size %d", seq, offset, f.size)
 	}
-	log.Printf("published message: seq %d", ack.Sequence)
-	return len(p), nil
+
+	f.chunks = append(f.chunks, chunk{offset: offset, size: size, seq: seq})
+	f.size += size
+	return nil
 }
 
+// Write appends p in chunks of at most the configured chunk size. It fails
+// with ErrConcurrentWrite if the file grew elsewhere since it was indexed.
+func (f *JetFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return 0, ErrClosed
+	}
+
+	n := 0
+	for n < len(p) {
+		data := p[n:min(len(p), n+f.chunkSize)]
+
+		var lastSeq uint64
+		if len(f.chunks) > 0 {
+			lastSeq = f.chunks[len(f.chunks)-1].seq
+		}
+		msg := nats.NewMsg(f.subject)
+		msg.Header.Set(OffsetHeader, strconv.FormatInt(f.size, 10))
+		msg.Data = data
+
+		ack, err := f.js.PublishMsg(msg, nats.ExpectLastSequencePerSubject(lastSeq))
+		var apiErr *nats.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode == nats.JSErrCodeStreamWrongLastSequence {
+			return n, ErrConcurrentWrite
+		}
+		if err != nil {
+			return n, fmt.Errorf("publish: %w", err)
+		}
+
+		f.chunks = append(f.chunks, chunk{offset: f.size, size: int64(len(data)), seq: ack.Sequence})
+		f.size += int64(len(data))
+		n += len(data)
+	}
+	return n, nil
+}
+
+// Seek sets the offset of the next Read in bytes. io.SeekEnd is relative to
+// the end including chunks appended by other writers. Seeking past the end is
+// allowed; reads there return io.EOF until the file grows.
 func (f *JetFile) Seek(offset int64, whence int) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return 0, ErrClosed
+	}
 	switch whence {
 	case io.SeekStart:
-		f.readSeq = uint64(offset)
 	case io.SeekCurrent:
-		f.readSeq += uint64(offset)
+		offset += f.offset
 	case io.SeekEnd:
-		if offset > 0 {
-			return 0, fmt.Errorf("invalid offset beyond end")
+		if err := f.refresh(); err != nil {
+			return 0, err
 		}
-		f.readSeq = f.readLast + uint64(offset)
+		offset += f.size
 	default:
-		return 0, fmt.Errorf("invalid whence")
+		return 0, fmt.Errorf("jetfile: invalid whence %d", whence)
 	}
-	return int64(f.readSeq), nil
+	if offset < 0 {
+		return 0, fmt.Errorf("jetfile: negative offset %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
 }
 
+// Read reads from the current offset. It returns at most the rest of one chunk
+// per call.
 func (f *JetFile) Read(p []byte) (int, error) {
-	msg, err := f.js.GetMsg(f.stream, f.readSeq)
-	if err != nil {
-		return 0, fmt.Errorf("get msg: %w", err)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return 0, ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes at off without moving the read offset.
+func (f *JetFile) ReadAt(p []byte, off int64) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return 0, ErrClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("jetfile: negative offset %d", off)
+	}
+
+	n := 0
+	for n < len(p) {
+		m, err := f.readAt(p[n:], off+int64(n))
+		n += m
+		if err != nil {
+			return n, err
+		}
 	}
-	n := copy(p, msg.Data)
-	f.readSeq++
 	return n, nil
 }
 
+// readAt copies from the chunk holding off. Callers hold the lock.
+func (f *JetFile) readAt(p []byte, off int64) (int, error) {
+	if off >= f.size {
+		if err := f.refresh(); err != nil {
+			return 0, err
+		}
+		if off >= f.size {
+			return 0, io.EOF
+		}
+	}
+
+	i := sort.Search(len(f.chunks), func(i int) bool {
+		return f.chunks[i].offset+f.chunks[i].size > off
+	})
+	c := f.chunks[i]
+
+	if f.cache == nil || f.cache.Sequence != c.seq {
+		msg, err := f.js.GetMsg(f.stream, c.seq)
+		if err != nil {
+			return 0, fmt.Errorf("get msg %d: %w", c.seq, err)
+		}
+		f.cache = msg
+	}
+	return copy(p, f.cache.Data[off-c.offset:]), nil
+}
+
 func (f *JetFile) Close() error {
-	// f.conn.Drain()
-	// f.conn.Close()
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return ErrClosed
+	}
+	f.closed = true
+	f.chunks = nil
+	f.cache = nil
 	return nil
 }
 
-func (f *JetFile) LoadAll(ctx context.Context) ([]byte, error) {
-	sub, err := f.js.PullSubscribe(f.subject[0], "reader")
+// LoadOption limits the messages LoadAll reads.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	startSeq  uint64
+	endSeq    uint64
+	startTime time.Time
+	endTime   time.Time
+}
+
+// WithSequenceRange reads the chunks stored at stream sequences start through
+// end, both included. Zero leaves a bound open.
+func WithSequenceRange(start, end uint64) LoadOption {
+	return func(o *loadOptions) {
+		o.startSeq = start
+		o.endSeq = end
+	}
+}
+
+// WithTimeRange reads the chunks stored at or after start and before end.
+// Zero times leave a bound open.
+func WithTimeRange(start, end time.Time) LoadOption {
+	return func(o *loadOptions) {
+		o.startTime = start
+		o.endTime = end
+	}
+}
+
+// LoadAll streams the file, or the range selected by opts, into w and returns
+// the number of bytes written. It reads through an ordered ephemeral consumer,
+// so it leaves no state on the server, and stops at the last chunk stored when
+// it was called.
+func (f *JetFile) LoadAll(ctx context.Context, w io.Writer, opts ...LoadOption) (int64, error) {
+	var o loadOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	last, err := f.js.GetLastMsg(f.stream, f.subject, nats.Context(ctx))
+	if errors.Is(err, nats.ErrMsgNotFound) {
+		return 0, nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("subscribe: %w", err)
+		return 0, fmt.Errorf("get last msg: %w", err)
 	}
 
-	var content []byte
+	end := last.Sequence
+	if o.endSeq > 0 {
+		end = min(end, o.endSeq)
+	}
+	if o.startSeq > end || (!o.startTime.IsZero() && o.startTime.After(last.Time)) {
+		return 0, nil
+	}
+
+	subOpts := []nats.SubOpt{nats.OrderedConsumer(), nats.BindStream(f.stream)}
+	switch {
+	case o.startSeq > 0:
+		subOpts = append(subOpts, nats.StartSequence(o.startSeq))
+	case !o.startTime.IsZero():
+		subOpts = append(subOpts, nats.StartTime(o.startTime))
+	default:
+		subOpts = append(subOpts, nats.DeliverAll())
+	}
+	sub, err := f.js.SubscribeSync(f.subject, subOpts...)
+	if err != nil {
+		return 0, fmt.Errorf("subscribe: %w", err)
+	}
+	defer sub.Unsubscribe()
+
+	var written int64
 	for {
-		msgs, err := sub.Fetch(1, nats.Context(ctx))
+		msg, err := sub.NextMsgWithContext(ctx)
 		if err != nil {
-			if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
-				break // done reading
-			}
-			return nil, fmt.Errorf("fetch: %w", err)
+			return written, fmt.Errorf("next msg: %w", err)
 		}
-		if len(msgs) == 0 {
-			break
+		meta, err := msg.Metadata()
+		if err != nil {
+			return written, fmt.Errorf("next msg: %w", err)
+		}
+		if meta.Sequence.Stream > end || (!o.endTime.IsZero() && !meta.Timestamp.Before(o.endTime)) {
+			return written, nil
+		}
+		if !o.startTime.IsZero() && meta.Timestamp.Before(o.startTime) {
+			continue // a start sequence and time were both given
+		}
+
+		n, err := w.Write(msg.Data)
+		written += int64(n)
+		if err != nil {
+			return written, err
 		}
-		for _, msg := range msgs {
-			content = append(content, msg.Data...)
-			msg.Ack()
+		if meta.Sequence.Stream >= end || meta.NumPending == 0 {
+			return written, nil
 		}
 	}
-	return content, nil
 }
@@ -1,20 +1,34 @@
 package file
 
 import (
+	"bytes"
+	"context"
 	"errors"
+	"io"
 	"log"
+	"os"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/nats-io/nats-server/v2/server"
 	"github.com/nats-io/nats.go"
 )
 
+const testStream = "JETFILE"
+
+var testJS nats.JetStreamContext
+
 func TestMain(m *testing.M) {
+	storeDir, err := os.MkdirTemp("", "jetfile")
+	if err != nil {
+		log.Fatalf("Error creating store dir: %v", err)
+	}
+
 	ns, err := server.NewServer(&server.Options{
+		Port:      server.RANDOM_PORT,
 		JetStream: true,
-		Debug:     true,
-		Trace:     true,
+		StoreDir:  storeDir,
 	})
 	if err != nil {
 		log.Fatalf("Error creating nats server: %v", err)
@@ -23,7 +37,7 @@ func TestMain(m *testing.M) {
 	go ns.Start()
 
 	if !ns.ReadyForConnections(4 * time.Second) {
-		log.Fatalf("Error starting nats server: %v", err)
+		log.Fatal("Error starting nats server")
 	}
 
 	nc, err := nats.Connect(ns.ClientURL())
@@ -36,16 +50,191 @@ func TestMain(m *testing.M) {
 		nc.Close()
 		log.Fatal(err)
 	}
-	stream := ""
-	subject := ""
 
 	_, err = js.AddStream(&nats.StreamConfig{
-		Name:     stream,
-		Subjects: []string{subject},
+		Name:     testStream,
+		Subjects: []string{"files.>"},
 		Storage:  nats.FileStorage,
 	})
 	if err != nil && !errors.Is(err, nats.ErrStreamNameAlreadyInUse) {
 		nc.Close()
 		log.Fatal(err)
 	}
+	testJS = js
+
+	code := m.Run()
+	nc.Close()
+	ns.Shutdown()
+	os.RemoveAll(storeDir)
+	os.Exit(code)
+}
+
+// testSubject returns a subject of the test stream for t alone, purged once t
+// is done so that repeated runs start from an empty file.
+func testSubject(t *testing.T, name string) string {
+	t.Helper()
+	subject := "files." + name
+	t.Cleanup(func() {
+		if err := testJS.PurgeStream(testStream, &nats.StreamPurgeRequest{Subject: subject}); err != nil {
+			t.Errorf("Error purging %s: %v", subject, err)
+		}
+	})
+	return subject
+}
+
+func TestJetFileReader(t *testing.T) {
+	subject := testSubject(t, "reader")
+	f, err := OpenJetFile(testJS, testStream, subject, WithChunkSize(7))
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer f.Close()
+
+	var content []byte
+	for i, part := range []string{"hello ", "", "chunked ", "append-only world, ", "x", "and then some more bytes"} {
+		n, err := f.Write([]byte(part))
+		if err != nil || n != len(part) {
+			t.Fatalf("Error writing part %d: %d, %v", i, n, err)
+		}
+		content = append(content, part...)
+	}
+	if f.Size() != int64(len(content)) {
+		t.Fatalf("Expected size %d, got %d", len(content), f.Size())
+	}
+
+	if err := iotest.TestReader(f, content); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenJetFile(testJS, testStream, subject)
+	if err != nil {
+		t.Fatalf("Error reopening file: %v", err)
+	}
+	defer reopened.Close()
+	if err := iotest.TestReader(reopened, content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJetFileSeekAndFollow(t *testing.T) {
+	subject := testSubject(t, "log")
+	writer, err := OpenJetFile(testJS, testStream, subject, WithChunkSize(4))
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer writer.Close()
+	reader, err := OpenJetFile(testJS, testStream, subject)
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer reader.Close()
+
+	if _, err := writer.Write([]byte("0123456789")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+
+	if pos, err := reader.Seek(-3, io.SeekEnd); err != nil || pos != 7 {
+		t.Fatalf("Expected offset 7 from a reader indexed before the write, got %d, %v", pos, err)
+	}
+	if pos, err := reader.Seek(-4, io.SeekEnd); err != nil || pos != 6 {
+		t.Fatalf("Expected offset 6, got %d, %v", pos, err)
+	}
+	buf := make([]byte, 8)
+	n, err := reader.Read(buf)
+	if err != nil || string(buf[:n]) != "67" {
+		t.Fatalf("Expected the rest of the chunk, got %q, %v", buf[:n], err)
+	}
+	if pos, _ := reader.Seek(0, io.SeekCurrent); pos != 8 {
+		t.Fatalf("Expected offset 8, got %d", pos)
+	}
+	if _, err := reader.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("Expected error seeking before the start")
+	}
+
+	if _, err := writer.Write([]byte("abc")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	rest, err := io.ReadAll(reader)
+	if err != nil || string(rest) != "89abc" {
+		t.Fatalf("Expected reader to follow the file, got %q, %v", rest, err)
+	}
+
+	if _, err := reader.Write([]byte("!")); err != nil {
+		t.Fatalf("Error writing from an up-to-date handle: %v", err)
+	}
+	if _, err := writer.Write([]byte("?")); !errors.Is(err, ErrConcurrentWrite) {
+		t.Fatalf("Expected ErrConcurrentWrite, got %v", err)
+	}
+
+	got := make([]byte, 14)
+	if n, err := writer.ReadAt(got, 0); err != nil || !bytes.Equal(got[:n], []byte("0123456789abc!")) {
+		t.Fatalf("Unexpected content %q, %v", got[:n], err)
+	}
+}
+
+func TestJetFileLoadAll(t *testing.T) {
+	ctx := context.Background()
+
+	subject := testSubject(t, "load")
+	f, err := OpenJetFile(testJS, testStream, subject, WithChunkSize(3))
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if n, err := f.LoadAll(ctx, &buf); err != nil || n != 0 {
+		t.Fatalf("Expected empty file, got %d, %v", n, err)
+	}
+
+	var seqs []uint64
+	var times []time.Time
+	for _, part := range []string{"aaa", "bbb", "ccc", "ddd"} {
+		if _, err := f.Write([]byte(part)); err != nil {
+			t.Fatalf("Error writing: %v", err)
+		}
+		last, err := testJS.GetLastMsg(testStream, subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, last.Sequence)
+		times = append(times, last.Time)
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Unrelated traffic on the stream must not end up in the file.
+	if _, err := testJS.Publish(testSubject(t, "other"), []byte("zzz")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		n, err := f.LoadAll(ctx, &buf)
+		if err != nil || n != 12 || buf.String() != "aaabbbcccddd" {
+			t.Fatalf("Load %d: unexpected content %q (%d), %v", i, buf.String(), n, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []LoadOption
+		want string
+	}{
+		{"sequence range", []LoadOption{WithSequenceRange(seqs[1], seqs[2])}, "bbbccc"},
+		{"open end sequence", []LoadOption{WithSequenceRange(seqs[2], 0)}, "cccddd"},
+		{"past the end", []LoadOption{WithSequenceRange(seqs[3]+100, 0)}, ""},
+		{"time range", []LoadOption{WithTimeRange(times[1], times[3])}, "bbbccc"},
+		{"open start time", []LoadOption{WithTimeRange(time.Time{}, times[1])}, "aaa"},
+		{"future time", []LoadOption{WithTimeRange(time.Now().Add(time.Hour), time.Time{})}, ""},
+	} {
+		buf.Reset()
+		if _, err := f.LoadAll(ctx, &buf, tc.opts...); err != nil || buf.String() != tc.want {
+			t.Fatalf("%s: expected %q, got %q, %v", tc.name, tc.want, buf.String(), err)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := f.LoadAll(canceled, &buf); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
 }
@@ -1,43 +1,81 @@
 package natsprovider
 
-import "github.com/nats-io/nats.go"
+import (
+	"context"
+	"errors"
+	"sync"
 
+	"github.com/nats-io/nats.go"
+)
+
+const defaultFileBucket = "natsprovider_files"
+
+// FileNATSProvider stores files in a JetStream object store bucket.
 type FileNATSProvider struct {
 	name        string
 	version     string
 	description string
 	nc          *nats.Conn
 	js          nats.JetStreamContext
+	bucket      string
 	core        CoreProvider
 	kv          KeyValueProvider
+	kvBucket    string
 	objStore    ObjectStoreProvider
-	stream      StreamProvider
-	config      ConfigProvider
-	file        FileProvider
+	stopWatch   context.CancelFunc // ends the watch shared by every WatchFile, nil when unused
+	watches     map[string]func(string, []byte)
+	lock        sync.Mutex
+	closed      bool
 }
 
-func NewFileNATSProvider(url string) (FileProvider, error) {
-	nc, err := nats.Connect(url)
+// NewFileNATSProvider connects to url and opens the object store bucket set
+// with WithObjectStore, creating it when missing.
+func NewFileNATSProvider(url string, opts ...Option) (FileProvider, error) {
+	o := newOptions(opts...)
+
+	natsOpts, err := o.connectOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	nc, err := nats.Connect(url, natsOpts...)
 	if err != nil {
 		return nil, err
 	}
 
 	js, err := nc.JetStream()
 	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	bucket := o.objBucket
+	if bucket == "" {
+		bucket = defaultFileBucket
+	}
+
+	objStore, err := NewObjectStoreProvider(js, bucket)
+	if err != nil {
+		nc.Close()
 		return nil, err
 	}
+	kvBucket := o.kvBucket
+	if kvBucket == "" {
+		kvBucket = defaultKVBucket
+	}
 
-	p := &FileNATSProvider{
+	return &FileNATSProvider{
 		name:        "file_nats",
 		version:     "1.0.0",
 		description: "File NATS Provider",
 		nc:          nc,
 		js:          js,
-	}
-
-	p.file = p
-
-	return p, nil
+		bucket:      bucket,
+		core:        NewCoreProvider(nc),
+		kvBucket:    kvBucket,
+		objStore:    objStore,
+		watches:     make(map[string]func(string, []byte)),
+	}, nil
 }
 
 func (p *FileNATSProvider) GetName() string        { return p.name }
@@ -45,48 +83,144 @@ func (p *FileNATSProvider) GetVersion() string     { return p.version }
 func (p *FileNATSProvider) GetDescription() string { return p.description }
 
 func (p *FileNATSProvider) GetConfig() map[string]any {
-	return map[string]any{"url": p.nc.ConnectedUrl()}
+	return map[string]any{
+		"url":    p.nc.ConnectedUrl(),
+		"bucket": p.bucket,
+	}
 }
 
 func (p *FileNATSProvider) Core() CoreProvider {
 	return p.core
 }
 
+// KeyValue opens the bucket set with WithKVBucket on first use.
 func (p *FileNATSProvider) KeyValue() (KeyValueProvider, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return nil, ErrClosed
+	}
+	if p.kv == nil {
+		kv, err := NewKeyValueProvider(p.js, p.kvBucket)
+		if err != nil {
+			return nil, err
+		}
+		p.kv = kv
+	}
 	return p.kv, nil
 }
 
 func (p *FileNATSProvider) GetFile(name string) ([]byte, error) {
-	// TODO implement me
-	panic("implement me")
+	return p.objStore.GetObject(context.Background(), name)
 }
 
 func (p *FileNATSProvider) PutFile(name string, data []byte) error {
-	// TODO implement me
-	panic("implement me")
+	_, err := p.objStore.PutObject(context.Background(), name, data)
+	return err
 }
 
 func (p *FileNATSProvider) DeleteFile(name string) error {
-	// TODO implement me
-	panic("implement me")
+	return p.objStore.DeleteObject(context.Background(), name)
 }
 
 func (p *FileNATSProvider) ListFiles() ([]string, error) {
-	// TODO implement me
-	panic("implement me")
+	names, err := p.objStore.ListObjects(context.Background())
+	if errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, nil
+	}
+	return names, err
 }
 
+// WatchFile calls cb with the new content every time name is written, and
+// with nil content when it is deleted. Every watched file shares one watcher
+// over the bucket, and callbacks run one at a time on its goroutine.
 func (p *FileNATSProvider) WatchFile(name string, cb func(string, []byte)) error {
-	// TODO implement me
-	panic("implement me")
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+	if _, ok := p.watches[name]; ok {
+		return nil // already watching
+	}
+
+	if p.stopWatch == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		err := p.objStore.Watch(ctx, func(event ObjectEvent) {
+			p.dispatch(ctx, event)
+		}, WithWatchUpdatesOnly())
+		if err != nil {
+			cancel()
+			return err
+		}
+		p.stopWatch = cancel
+	}
+	p.watches[name] = cb
+	return nil
+}
+
+// dispatch hands an update of the shared watch to the callback of the
+// watched file, unless the watch bound to ctx was stopped meanwhile.
+func (p *FileNATSProvider) dispatch(ctx context.Context, event ObjectEvent) {
+	name := event.Info.Name
+	p.lock.Lock()
+	cb, ok := p.watches[name]
+	p.lock.Unlock()
+	if !ok || ctx.Err() != nil {
+		return
+	}
+
+	if event.Op == ObjectDelete {
+		cb(name, nil)
+		return
+	}
+	data, err := p.objStore.GetObject(ctx, name)
+	if err != nil {
+		// Overwritten or deleted again before we could read it; the next
+		// update delivers the latest state.
+		return
+	}
+	cb(name, data)
 }
 
+// UnwatchFile stops the callbacks for name, and the shared watcher once no
+// file is watched.
 func (p *FileNATSProvider) UnwatchFile(name string) error {
-	// TODO implement me
-	panic("implement me")
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	delete(p.watches, name)
+	p.stopWatcher()
+	return nil
 }
 
+// stopWatcher stops the shared watch when nothing is watched or the
+// provider is closed. p.lock must be held.
+func (p *FileNATSProvider) stopWatcher() {
+	if p.stopWatch == nil || (len(p.watches) > 0 && !p.closed) {
+		return
+	}
+	p.stopWatch()
+	p.stopWatch = nil
+}
+
+// Close stops every watcher and drains the connection.
 func (p *FileNATSProvider) Close() error {
-	// TODO implement me
-	panic("implement me")
+	p.lock.Lock()
+	var errs []error
+	p.closed = true
+	clear(p.watches)
+	p.stopWatcher()
+	errs = append(errs, p.objStore.Close())
+	if p.kv != nil {
+		errs = append(errs, p.kv.Close())
+	}
+	p.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultShutdownTimeout)
+	defer cancel()
+	errs = append(errs, drainConn(ctx, p.nc))
+	return errors.Join(errs...)
 }
@@ -0,0 +1,132 @@
+package natsprovider
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFileNATSProvider(t *testing.T) {
+	p, err := NewFileNATSProvider(testObj.url, WithObjectStore("files"), WithKVBucket("files_kv"))
+	if err != nil {
+		t.Fatalf("Error creating file provider: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("files")
+
+	files, err := p.ListFiles()
+	if err != nil || len(files) != 0 {
+		t.Fatalf("Expected empty bucket, got %v, %v", files, err)
+	}
+
+	fp := p.(*FileNATSProvider)
+	if fp.Core() == nil {
+		t.Fatal("Core provider is nil")
+	}
+	kv, err := fp.KeyValue()
+	if err != nil || kv == nil {
+		t.Fatalf("Error getting key-value provider: %v", err)
+	}
+	defer testObj.js.DeleteKeyValue("files_kv")
+
+	type update struct {
+		name string
+		data []byte
+	}
+	updates := make(chan update, 4)
+	if err := p.WatchFile("config.yaml", func(name string, data []byte) {
+		updates <- update{name, data}
+	}); err != nil {
+		t.Fatalf("Error watching file: %v", err)
+	}
+
+	// A second file shares the bucket watcher of the first.
+	watchers := func() int {
+		objStore := fp.objStore.(*objectStoreProvider)
+		objStore.lock.Lock()
+		defer objStore.lock.Unlock()
+		return len(objStore.watchers)
+	}
+	others := make(chan update, 4)
+	if err := p.WatchFile("other.txt", func(name string, data []byte) {
+		others <- update{name, data}
+	}); err != nil {
+		t.Fatalf("Error watching file: %v", err)
+	}
+	if n := watchers(); n != 1 {
+		t.Fatalf("Expected one watcher shared by every watched file, got %d", n)
+	}
+
+	if err := p.PutFile("other.txt", []byte("other")); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	select {
+	case u := <-others:
+		if u.name != "other.txt" || string(u.data) != "other" {
+			t.Fatalf("Unexpected update: %s %q", u.name, u.data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for file update")
+	}
+	if err := p.UnwatchFile("other.txt"); err != nil {
+		t.Fatalf("Error unwatching file: %v", err)
+	}
+	if n := watchers(); n != 1 {
+		t.Fatalf("Expected the watcher to keep running while a file is watched, got %d", n)
+	}
+
+	if err := p.PutFile("config.yaml", []byte("v: 1")); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+
+	select {
+	case u := <-updates:
+		if u.name != "config.yaml" || string(u.data) != "v: 1" {
+			t.Fatalf("Unexpected update: %s %q", u.name, u.data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for file update")
+	}
+
+	data, err := p.GetFile("config.yaml")
+	if err != nil || string(data) != "v: 1" {
+		t.Fatalf("Unexpected file content %q: %v", data, err)
+	}
+	files, err = p.ListFiles()
+	if err != nil || len(files) != 2 {
+		t.Fatalf("Expected 2 files, got %v, %v", files, err)
+	}
+
+	if err := p.DeleteFile("config.yaml"); err != nil {
+		t.Fatalf("Error deleting file: %v", err)
+	}
+	select {
+	case u := <-updates:
+		if u.data != nil {
+			t.Fatalf("Expected nil content on delete, got %q", u.data)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for delete update")
+	}
+
+	if err := p.UnwatchFile("config.yaml"); err != nil {
+		t.Fatalf("Error unwatching file: %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for watchers() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the watcher to stop with the last watched file")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := p.PutFile("config.yaml", []byte("v: 2")); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	select {
+	case u := <-updates:
+		t.Fatalf("Unexpected update after unwatch: %q", u.data)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := p.Close(); err != nil {
+		t.Fatalf("Error closing file provider: %v", err)
+	}
+}
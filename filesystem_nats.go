@@ -1,102 +1,777 @@
 package natsprovider
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
 	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
-	"github.com/pkg/sftp"
+	"github.com/nats-io/nats.go"
 )
 
-type File struct {
-	client *sftp.Client
-	fd     *sftp.File
-	hfs    os.File
+const (
+	fsDirKeyPrefix  = "dir."
+	fsMetaMode      = "fs.mode"
+	fsMetaModTime   = "fs.mtime"
+	defaultFileMode = 0o644
+	defaultDirMode  = 0o755
+)
+
+var errWriteAtInAppendMode = errors.New("WriteAt in append mode")
+
+// filesystem stores file content as objects named by their cleaned path, and
+// directories as entries in a key-value bucket. Directories implied by object
+// names exist even without an entry; only Mkdir and Chtimes write one, and
+// Rename moves it. Operations spanning several objects, such as renaming a
+// directory, are not atomic.
+type filesystem struct {
+	ctx     context.Context
+	objects ObjectStoreProvider
+	dirs    KeyValueProvider
 }
 
-func FileOpen(s *sftp.Client, name string) (*File, error) {
-	fd, err := s.Open(name)
-	if err != nil {
-		return &File{}, err
+// dirMeta is the value stored for every directory.
+type dirMeta struct {
+	Mode    fs.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+// NewFilesystemProvider returns a writable file system keeping file content in
+// objects and directories in dirs. ctx is used for every call made through the
+// file system and its files.
+func NewFilesystemProvider(ctx context.Context, objects ObjectStoreProvider, dirs KeyValueProvider) FilesystemProvider {
+	return &filesystem{ctx: ctx, objects: objects, dirs: dirs}
+}
+
+// cleanPath maps name to the object name it is stored under. The root is "".
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func dirKey(p string) string {
+	return fsDirKeyPrefix + base64.RawURLEncoding.EncodeToString([]byte(p))
+}
+
+func (f *filesystem) Open(name string) (File, error) {
+	return f.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (f *filesystem) Create(name string) (File, error) {
+	return f.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, defaultFileMode)
+}
+
+// OpenFile follows os.OpenFile. Content is loaded on first access and written
+// back on Sync and Close.
+func (f *filesystem) OpenFile(name string, flag int, perm fs.FileMode) (File, error) {
+	p := cleanPath(name)
+	file := &natsFile{fs: f, name: name, path: p, flag: flag}
+
+	// Creating a file skips the bucket listing that finds implied
+	// directories, so its cost does not grow with the bucket.
+	info, err := f.lookup(p)
+	if errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE == 0 {
+		info, err = f.stat(p)
+	}
+	switch {
+	case err == nil && info.IsDir():
+		if file.writable() {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: syscall.EISDIR}
+		}
+		file.info = info
+		return file, nil
+
+	case err == nil:
+		if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+		}
+		file.info = info
+		if flag&os.O_TRUNC != 0 && file.writable() {
+			file.loaded = true
+			file.touch()
+		}
+		return file, nil
+
+	case errors.Is(err, fs.ErrNotExist) && flag&os.O_CREATE != 0:
+		if err := f.checkParent(p); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		file.info = &objectFileInfo{name: path.Base(p), mode: perm & fs.ModePerm}
+		file.loaded = true
+		file.touch()
+		return file, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+}
+
+func (f *filesystem) Mkdir(name string, perm fs.FileMode) error {
+	p := cleanPath(name)
+	if _, err := f.stat(p); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if err := f.checkParent(p); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if err := f.putDir(p, dirMeta{Mode: perm & fs.ModePerm, ModTime: time.Now()}); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
 	}
-	return &File{fd: fd, client: s}, nil
+	return nil
 }
 
-func FileCreate(s *sftp.Client, name string) (*File, error) {
-	fd, err := s.Create(name)
+func (f *filesystem) MkdirAll(name string, perm fs.FileMode) error {
+	p := cleanPath(name)
+	if p == "" {
+		return nil
+	}
+
+	info, err := f.stat(p)
+	if err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := path.Dir(p); parent != "." {
+		if err := f.MkdirAll(parent, perm); err != nil {
+			return err
+		}
+	}
+	err = f.Mkdir(p, perm)
+	if errors.Is(err, fs.ErrExist) {
+		return nil // created concurrently
+	}
+	return err
+}
+
+// Remove deletes a file or an empty directory.
+func (f *filesystem) Remove(name string) error {
+	p := cleanPath(name)
+	if p == "" {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+
+	info, err := f.stat(p)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if !info.IsDir() {
+		err = f.objects.DeleteObject(f.ctx, p)
+	} else if children, cerr := f.children(p); cerr != nil {
+		err = cerr
+	} else if len(children) > 0 {
+		err = syscall.ENOTEMPTY
+	} else {
+		err = f.deleteDir(p)
+	}
 	if err != nil {
-		return &File{}, err
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
 	}
-	return &File{fd: fd, client: s}, nil
+	return nil
 }
 
-func (f *File) Close() error {
-	return f.fd.Close()
+// Rename moves a file, replacing any file at newname, or a directory to a
+// path that does not exist yet. File content is copied, so renaming large
+// files or directories costs as much as rewriting them.
+func (f *filesystem) Rename(oldname, newname string) error {
+	oldPath, newPath := cleanPath(oldname), cleanPath(newname)
+	if err := f.rename(oldPath, newPath); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+func (f *filesystem) rename(oldPath, newPath string) error {
+	if oldPath == "" || newPath == "" {
+		return fs.ErrInvalid
+	}
+	info, err := f.stat(oldPath)
+	if err != nil {
+		return err
+	}
+	if oldPath == newPath {
+		return nil
+	}
+	if err := f.checkParent(newPath); err != nil {
+		return err
+	}
+
+	target, err := f.stat(newPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if !info.IsDir() {
+		if target != nil && target.IsDir() {
+			return syscall.EISDIR
+		}
+		return f.moveObject(oldPath, newPath)
+	}
+
+	if target != nil {
+		return fs.ErrExist
+	}
+	if strings.HasPrefix(newPath, oldPath+"/") {
+		return fs.ErrInvalid
+	}
+
+	objects, err := f.objects.ListObjectInfo(f.ctx)
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return err
+	}
+	for _, obj := range objects {
+		if rest, ok := strings.CutPrefix(obj.Name, oldPath+"/"); ok {
+			if err := f.moveObject(obj.Name, newPath+"/"+rest); err != nil {
+				return err
+			}
+		}
+	}
+
+	dirs, err := f.dirPaths()
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		rest, ok := strings.CutPrefix(dir, oldPath)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			continue
+		}
+		meta, err := f.getDir(dir)
+		if err != nil {
+			return err
+		}
+		if err := f.putDir(newPath+rest, meta); err != nil {
+			return err
+		}
+		if err := f.deleteDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (f *File) Name() string {
-	return f.fd.Name()
+func (f *filesystem) moveObject(oldPath, newPath string) error {
+	r, info, err := f.objects.GetObjectStream(f.ctx, oldPath)
+	if err != nil {
+		return err
+	}
+	_, err = f.objects.PutObjectStream(f.ctx, newPath, r, info.ObjectMeta)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return f.objects.DeleteObject(f.ctx, oldPath)
 }
 
-func (f *File) Stat() (os.FileInfo, error) {
-	return f.fd.Stat()
+func (f *filesystem) Stat(name string) (fs.FileInfo, error) {
+	info, err := f.stat(cleanPath(name))
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return info, nil
 }
 
-func (f *File) Sync() error {
+// Chtimes sets the modification time; atime is not tracked.
+func (f *filesystem) Chtimes(name string, atime, mtime time.Time) error {
+	p := cleanPath(name)
+	err := f.chtimes(p, mtime)
+	if err != nil {
+		return &fs.PathError{Op: "chtimes", Path: name, Err: err}
+	}
 	return nil
 }
 
-func (f *File) Truncate(size int64) error {
-	return f.fd.Truncate(size)
+func (f *filesystem) chtimes(p string, mtime time.Time) error {
+	if p == "" {
+		return nil
+	}
+	info, err := f.stat(p)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return f.putDir(p, dirMeta{Mode: info.Mode().Perm(), ModTime: mtime})
+	}
+
+	obj, err := f.objects.GetObjectInfo(f.ctx, p)
+	if err != nil {
+		return err
+	}
+	meta := obj.ObjectMeta
+	meta.Metadata = maps.Clone(meta.Metadata)
+	if meta.Metadata == nil {
+		meta.Metadata = make(map[string]string)
+	}
+	meta.Metadata[fsMetaModTime] = mtime.UTC().Format(time.RFC3339Nano)
+	return f.objects.UpdateMeta(f.ctx, p, meta)
 }
 
-func (f *File) Read(b []byte) (n int, err error) {
-	return f.fd.Read(b)
+// stat resolves p to a file, a directory entry or a directory implied by
+// object names, in that order. Only the last step lists the bucket.
+func (f *filesystem) stat(p string) (*objectFileInfo, error) {
+	info, err := f.lookup(p)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return info, err
+	}
+
+	objects, err := f.objects.ListObjects(f.ctx)
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, err
+	}
+	prefix := p + "/"
+	if !slices.ContainsFunc(objects, func(name string) bool { return strings.HasPrefix(name, prefix) }) {
+		return nil, fs.ErrNotExist
+	}
+	return &objectFileInfo{name: path.Base(p), mode: fs.ModeDir | defaultDirMode}, nil
 }
 
-func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	return f.fd.ReadAt(b, off)
+// lookup resolves p to a file or a directory entry without listing the
+// bucket.
+func (f *filesystem) lookup(p string) (*objectFileInfo, error) {
+	if p == "" {
+		return &objectFileInfo{name: "/", mode: fs.ModeDir | defaultDirMode}, nil
+	}
+
+	obj, err := f.objects.GetObjectInfo(f.ctx, p)
+	if err == nil {
+		return fsFileInfo(p, obj), nil
+	}
+	if !errors.Is(err, nats.ErrObjectNotFound) {
+		return nil, err
+	}
+
+	meta, err := f.getDir(p)
+	if err != nil {
+		return nil, err
+	}
+	return &objectFileInfo{name: path.Base(p), mode: fs.ModeDir | meta.Mode, modTime: meta.ModTime}, nil
 }
 
-func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
-	res, err = f.client.ReadDir(f.Name())
+// checkParent fails unless the parent of p is an existing directory.
+func (f *filesystem) checkParent(p string) error {
+	parent := path.Dir(p)
+	if parent == "." {
+		return nil
+	}
+	info, err := f.stat(parent)
 	if err != nil {
-		return
+		return err
+	}
+	if !info.IsDir() {
+		return syscall.ENOTDIR
+	}
+	return nil
+}
+
+// children lists the entries directly inside dir, sorted by name.
+func (f *filesystem) children(dir string) ([]fs.FileInfo, error) {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	entries := make(map[string]*objectFileInfo)
+
+	objects, err := f.objects.ListObjectInfo(f.ctx)
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, err
 	}
-	if count > 0 {
-		if len(res) > count {
-			res = res[:count]
+	for _, obj := range objects {
+		rest, ok := strings.CutPrefix(obj.Name, prefix)
+		if !ok || rest == "" {
+			continue
 		}
+		if child, _, nested := strings.Cut(rest, "/"); nested {
+			if _, ok := entries[child]; !ok || !entries[child].IsDir() {
+				entries[child] = &objectFileInfo{name: child, mode: fs.ModeDir | defaultDirMode}
+			}
+		} else if _, ok := entries[rest]; !ok {
+			entries[rest] = fsFileInfo(obj.Name, obj)
+		}
+	}
+
+	dirs, err := f.dirPaths()
+	if err != nil {
+		return nil, err
 	}
-	return
+	for _, d := range dirs {
+		rest, ok := strings.CutPrefix(d, prefix)
+		if !ok || rest == "" || strings.Contains(rest, "/") {
+			continue
+		}
+		meta, err := f.getDir(d)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // removed meanwhile
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries[rest] = &objectFileInfo{name: rest, mode: fs.ModeDir | meta.Mode, modTime: meta.ModTime}
+	}
+
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, name := range slices.Sorted(maps.Keys(entries)) {
+		infos = append(infos, entries[name])
+	}
+	return infos, nil
 }
 
-func (f *File) Readdirnames(n int) (names []string, err error) {
-	data, err := f.Readdir(n)
+func (f *filesystem) dirPaths() ([]string, error) {
+	keys, err := f.dirs.List(f.ctx)
+	if errors.Is(err, nats.ErrNoKeysFound) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	for _, v := range data {
-		names = append(names, v.Name())
+
+	var dirs []string
+	for _, key := range keys {
+		encoded, ok := strings.CutPrefix(key, fsDirKeyPrefix)
+		if !ok {
+			continue
+		}
+		if p, err := base64.RawURLEncoding.DecodeString(encoded); err == nil {
+			dirs = append(dirs, string(p))
+		}
+	}
+	return dirs, nil
+}
+
+func (f *filesystem) getDir(p string) (dirMeta, error) {
+	var meta dirMeta
+	value, err := f.dirs.Get(f.ctx, dirKey(p))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return meta, fs.ErrNotExist
+	}
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal([]byte(value), &meta)
+	return meta, err
+}
+
+func (f *filesystem) putDir(p string, meta dirMeta) error {
+	value, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return f.dirs.Set(f.ctx, dirKey(p), string(value))
+}
+
+func (f *filesystem) deleteDir(p string) error {
+	err := f.dirs.Delete(f.ctx, dirKey(p))
+	if errors.Is(err, nats.ErrKeyNotFound) {
+		return nil // implied by object names only
+	}
+	return err
+}
+
+// fsFileInfo reads the mode and modification time kept in the object meta
+// data, falling back to the object's own time for objects written elsewhere.
+func fsFileInfo(p string, obj *ObjectInfo) *objectFileInfo {
+	info := &objectFileInfo{name: path.Base(p), size: int64(obj.Size), mode: defaultFileMode, modTime: obj.ModTime}
+	if mode, err := strconv.ParseUint(obj.Metadata[fsMetaMode], 8, 32); err == nil {
+		info.mode = fs.FileMode(mode) & fs.ModePerm
+	}
+	if mtime, err := time.Parse(time.RFC3339Nano, obj.Metadata[fsMetaModTime]); err == nil {
+		info.modTime = mtime
 	}
-	return
+	return info
 }
 
-func (f *File) Seek(offset int64, whence int) (int64, error) {
-	return f.fd.Seek(offset, whence)
+// natsFile buffers the whole content in memory once it is first accessed.
+type natsFile struct {
+	fs   *filesystem
+	name string
+	path string
+	flag int
+
+	lock      sync.Mutex
+	info      *objectFileInfo
+	data      []byte
+	loaded    bool
+	dirty     bool
+	offset    int64
+	dirOffset int
+	closed    bool
 }
 
-func (f *File) Write(b []byte) (n int, err error) {
-	return f.fd.Write(b)
+func (f *natsFile) Name() string { return f.name }
+
+func (f *natsFile) readable() bool { return f.flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY }
+func (f *natsFile) writable() bool { return f.flag&(os.O_WRONLY|os.O_RDWR) != 0 }
+
+func (f *natsFile) touch() {
+	f.dirty = true
+	f.info.modTime = time.Now()
 }
 
-// TODO
-func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	return 0, nil
+// check validates the file state for op and loads the content when needed.
+func (f *natsFile) check(op string, write bool) error {
+	var err error
+	switch {
+	case f.closed:
+		err = fs.ErrClosed
+	case f.info.IsDir():
+		err = syscall.EISDIR
+	case write && !f.writable(), !write && !f.readable():
+		err = syscall.EBADF
+	case !f.loaded:
+		f.data, err = f.fs.objects.GetObject(f.fs.ctx, f.path)
+		f.loaded = err == nil
+	}
+	if err != nil {
+		return &fs.PathError{Op: op, Path: f.name, Err: err}
+	}
+	return nil
 }
 
-func (f *File) WriteString(s string) (ret int, err error) {
-	return f.fd.Write([]byte(s))
+func (f *natsFile) Read(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+	return n, err
 }
 
-type FilesystemProvider interface {
-	os.File
+func (f *natsFile) ReadAt(p []byte, off int64) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.check("read", false); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrInvalid}
+	}
+	n, err := f.readAt(p, off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (f *natsFile) readAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	return copy(p, f.data[off:]), nil
+}
+
+func (f *natsFile) Write(p []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.data))
+	}
+	n := f.writeAt(p, f.offset)
+	f.offset += int64(n)
+	return n, nil
+}
+
+func (f *natsFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *natsFile) WriteAt(p []byte, off int64) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.check("write", true); err != nil {
+		return 0, err
+	}
+	if f.flag&os.O_APPEND != 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: errWriteAtInAppendMode}
+	}
+	if off < 0 {
+		return 0, &fs.PathError{Op: "write", Path: f.name, Err: fs.ErrInvalid}
+	}
+	return f.writeAt(p, off), nil
+}
+
+// writeAt writes p at off, growing the content with zeros past its end.
+func (f *natsFile) writeAt(p []byte, off int64) int {
+	if end := off + int64(len(p)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	copy(f.data[off:], p)
+	f.touch()
+	return len(p)
+}
+
+func (f *natsFile) Seek(offset int64, whence int) (int64, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrClosed}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size()
+	default:
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &fs.PathError{Op: "seek", Path: f.name, Err: fs.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *natsFile) Truncate(size int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.check("truncate", true); err != nil {
+		return err
+	}
+	if size < 0 {
+		return &fs.PathError{Op: "truncate", Path: f.name, Err: fs.ErrInvalid}
+	}
+	if size <= int64(len(f.data)) {
+		f.data = f.data[:size]
+	} else {
+		f.data = append(f.data, make([]byte, size-int64(len(f.data)))...)
+	}
+	f.touch()
+	return nil
+}
+
+func (f *natsFile) size() int64 {
+	if f.loaded {
+		return int64(len(f.data))
+	}
+	return f.info.size
+}
+
+func (f *natsFile) Stat() (fs.FileInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: fs.ErrClosed}
+	}
+	info := *f.info
+	if !info.IsDir() {
+		info.size = f.size()
+	}
+	return &info, nil
+}
+
+// Sync writes buffered changes back to the object store.
+func (f *natsFile) Sync() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: fs.ErrClosed}
+	}
+	return f.sync()
+}
+
+func (f *natsFile) sync() error {
+	if !f.dirty {
+		return nil
+	}
+
+	var meta ObjectMeta
+	if obj, err := f.fs.objects.GetObjectInfo(f.fs.ctx, f.path); err == nil {
+		meta = obj.ObjectMeta // keep what other clients set
+	} else if !errors.Is(err, nats.ErrObjectNotFound) {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+	meta.Metadata = maps.Clone(meta.Metadata)
+	if meta.Metadata == nil {
+		meta.Metadata = make(map[string]string)
+	}
+	meta.Metadata[fsMetaMode] = strconv.FormatUint(uint64(f.info.mode.Perm()), 8)
+	meta.Metadata[fsMetaModTime] = f.info.modTime.UTC().Format(time.RFC3339Nano)
+
+	if _, err := f.fs.objects.PutObjectStream(f.fs.ctx, f.path, bytes.NewReader(f.data), meta); err != nil {
+		return &fs.PathError{Op: "sync", Path: f.name, Err: err}
+	}
+	f.dirty = false
+	return nil
+}
+
+// Close writes buffered changes back and releases the content.
+func (f *natsFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	err := f.sync()
+	f.closed = true
+	f.data = nil
+	return err
+}
+
+// Readdir follows os.File.Readdir.
+func (f *natsFile) Readdir(count int) ([]fs.FileInfo, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.closed {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: fs.ErrClosed}
+	}
+	if !f.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+
+	children, err := f.fs.children(f.path)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: err}
+	}
+	rest := children[min(f.dirOffset, len(children)):]
+	if count <= 0 {
+		f.dirOffset = len(children)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(count, len(rest))]
+	f.dirOffset += len(rest)
+	return rest, nil
+}
+
+func (f *natsFile) Readdirnames(n int) ([]string, error) {
+	infos, err := f.Readdir(n)
+	names := make([]string, len(infos))
+	for i, info := range infos {
+		names[i] = info.Name()
+	}
+	return names, err
 }
@@ -0,0 +1,192 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFilesystemProvider(t *testing.T) {
+	ctx := context.Background()
+
+	objects, err := NewObjectStoreProvider(testObj.js, "fs_objects")
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("fs_objects")
+	dirs, err := NewKeyValueProvider(testObj.js, "fs_dirs")
+	if err != nil {
+		t.Fatalf("Error creating key-value store: %v", err)
+	}
+	defer testObj.js.DeleteKeyValue("fs_dirs")
+
+	fsys := NewFilesystemProvider(ctx, objects, dirs)
+
+	if err := fsys.Mkdir("/docs", 0o750); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if err := fsys.Mkdir("/docs", 0o750); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Expected fs.ErrExist, got %v", err)
+	}
+	if _, err := fsys.Create("/missing/file.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist for missing parent, got %v", err)
+	}
+
+	f, err := fsys.Create("/docs/notes.txt")
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	if _, err := f.WriteString("hello world"); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("NATS!"), 6); err != nil {
+		t.Fatalf("Error writing at offset: %v", err)
+	}
+	if _, err := f.WriteAt([]byte("?"), 14); err != nil {
+		t.Fatalf("Error writing past the end: %v", err)
+	}
+	if pos, err := f.Seek(-4, io.SeekEnd); err != nil || pos != 11 {
+		t.Fatalf("Expected offset 11, got %d, %v", pos, err)
+	}
+	buf := make([]byte, 4)
+	if n, err := f.Read(buf); err != nil || string(buf[:n]) != "\x00\x00\x00?" {
+		t.Fatalf("Unexpected read %q, %v", buf[:n], err)
+	}
+	if err := f.Truncate(11); err != nil {
+		t.Fatalf("Error truncating: %v", err)
+	}
+	if _, err := objects.GetObjectInfo(ctx, "docs/notes.txt"); err == nil {
+		t.Fatal("Expected no object before Sync")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+	if data, err := objects.GetObject(ctx, "docs/notes.txt"); err != nil || string(data) != "hello NATS!" {
+		t.Fatalf("Unexpected object content %q, %v", data, err)
+	}
+
+	f, err = fsys.OpenFile("/docs/notes.txt", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Error opening for append: %v", err)
+	}
+	if _, err := f.Write([]byte(" bye")); err != nil {
+		t.Fatalf("Error appending: %v", err)
+	}
+	if _, err := f.Read(buf); !errors.Is(err, syscall.EBADF) {
+		t.Fatalf("Expected EBADF reading a write-only file, got %v", err)
+	}
+	if _, err := f.WriteAt(buf, 0); err == nil {
+		t.Fatal("Expected error from WriteAt in append mode")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+	if _, err := fsys.OpenFile("/docs/notes.txt", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o600); !errors.Is(err, fs.ErrExist) {
+		t.Fatalf("Expected fs.ErrExist, got %v", err)
+	}
+
+	f, err = fsys.Open("docs/notes.txt")
+	if err != nil {
+		t.Fatalf("Error opening: %v", err)
+	}
+	buf = make([]byte, 4)
+	if n, err := f.ReadAt(buf, 11); err != nil || string(buf[:n]) != " bye" {
+		t.Fatalf("Unexpected ReadAt %q, %v", buf[:n], err)
+	}
+	if _, err := f.Write(buf); !errors.Is(err, syscall.EBADF) {
+		t.Fatalf("Expected EBADF writing a read-only file, got %v", err)
+	}
+	f.Close()
+
+	info, err := fsys.Stat("/docs/notes.txt")
+	if err != nil || info.Size() != 15 || info.Mode() != 0o644 || info.IsDir() {
+		t.Fatalf("Unexpected file info %v, %v", info, err)
+	}
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := fsys.Chtimes("/docs/notes.txt", mtime, mtime); err != nil {
+		t.Fatalf("Error setting times: %v", err)
+	}
+	if err := fsys.Chtimes("/docs", mtime, mtime); err != nil {
+		t.Fatalf("Error setting directory times: %v", err)
+	}
+	for _, name := range []string{"/docs/notes.txt", "/docs"} {
+		if info, err := fsys.Stat(name); err != nil || !info.ModTime().Equal(mtime) {
+			t.Fatalf("Expected %s mtime %v, got %v, %v", name, mtime, info, err)
+		}
+	}
+	if info, err := fsys.Stat("/docs"); err != nil || info.Mode() != fs.ModeDir|0o750 {
+		t.Fatalf("Unexpected directory info %v, %v", info, err)
+	}
+
+	if err := fsys.MkdirAll("/docs/a/b", 0o755); err != nil {
+		t.Fatalf("Error creating directories: %v", err)
+	}
+	if _, err := objects.PutObject(ctx, "docs/implicit/x.txt", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fsys.Stat("/docs/implicit"); err != nil || !info.IsDir() {
+		t.Fatalf("Expected an implied directory, got %v, %v", info, err)
+	}
+	dir, err := fsys.Open("/docs")
+	if err != nil {
+		t.Fatalf("Error opening directory: %v", err)
+	}
+	first, err := dir.Readdirnames(2)
+	if err != nil {
+		t.Fatalf("Error reading directory: %v", err)
+	}
+	rest, err := dir.Readdirnames(-1)
+	if err != nil {
+		t.Fatalf("Error reading directory: %v", err)
+	}
+	if names := append(first, rest...); !slices.Equal(names, []string{"a", "implicit", "notes.txt"}) {
+		t.Fatalf("Unexpected directory entries %v", names)
+	}
+	if _, err := dir.Readdir(1); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+	dir.Close()
+	if exists, err := dirs.Exists(ctx, dirKey("docs/implicit")); err != nil || exists {
+		t.Fatalf("Expected listing to leave the implied directory unrecorded, got %v, %v", exists, err)
+	}
+
+	if err := fsys.Rename("/docs", "/archive"); err != nil {
+		t.Fatalf("Error renaming directory: %v", err)
+	}
+	for _, name := range []string{"/archive/notes.txt", "/archive/a/b", "/archive/implicit/x.txt"} {
+		if _, err := fsys.Stat(name); err != nil {
+			t.Fatalf("Expected %s after rename: %v", name, err)
+		}
+	}
+	if _, err := fsys.Stat("/docs"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected /docs to be gone, got %v", err)
+	}
+	if info, err := fsys.Stat("/archive/notes.txt"); err != nil || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Rename lost meta data: %v, %v", info, err)
+	}
+	if err := fsys.Rename("/archive/notes.txt", "/notes.txt"); err != nil {
+		t.Fatalf("Error renaming file: %v", err)
+	}
+
+	if err := fsys.Remove("/archive/a"); !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Fatalf("Expected ENOTEMPTY, got %v", err)
+	}
+	for _, name := range []string{"/archive/a/b", "/archive/a", "/archive/implicit/x.txt", "/archive", "/notes.txt"} {
+		if err := fsys.Remove(name); err != nil {
+			t.Fatalf("Error removing %s: %v", name, err)
+		}
+	}
+	root, err := fsys.Open("/")
+	if err != nil {
+		t.Fatalf("Error opening root: %v", err)
+	}
+	if names, err := root.Readdirnames(-1); err != nil || len(names) != 0 {
+		t.Fatalf("Expected empty root, got %v, %v", names, err)
+	}
+}
@@ -0,0 +1,237 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// objectFS presents an object store bucket as a read-only file tree. Every
+// call lists or reads the bucket live, so changes show up immediately.
+type objectFS struct {
+	ctx   context.Context
+	store ObjectStoreProvider
+}
+
+// NewObjectFS returns an fs.FS over store where "/" in object names separates
+// directories. ctx is used for every call made through the file system.
+// Objects whose names are not valid fs paths, and bucket links, are hidden;
+// object links read as the object they point at.
+func NewObjectFS(ctx context.Context, store ObjectStoreProvider) ObjectFS {
+	return &objectFS{ctx: ctx, store: store}
+}
+
+func (f *objectFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name != "." {
+		info, err := f.objectInfo(name)
+		if err == nil {
+			return &objectFile{fs: f, name: name, info: info}, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	entries, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &objectDir{info: dirInfo(name), entries: entries}, nil
+}
+
+func (f *objectFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: errors.Unwrap(err)}
+	}
+	defer file.Close()
+	return file.Stat()
+}
+
+func (f *objectFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	entries, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+func (f *objectFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	if _, err := f.objectInfo(name); err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	data, err := f.store.GetObject(f.ctx, name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+// objectInfo returns the file info of the object stored under name, resolving
+// links, or an error wrapping fs.ErrNotExist.
+func (f *objectFS) objectInfo(name string) (*objectFileInfo, error) {
+	info, err := f.store.GetObjectInfo(f.ctx, name)
+	if errors.Is(err, nats.ErrObjectNotFound) {
+		return nil, fs.ErrNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Link != nil {
+		if info.Link.Name == "" {
+			return nil, fs.ErrNotExist // bucket link
+		}
+		if info, err = f.store.ResolveLink(f.ctx, name); err != nil {
+			return nil, err
+		}
+	}
+	return fileInfo(path.Base(name), info), nil
+}
+
+// readDir lists the direct children of dir, sorted by name. A directory
+// exists as long as some object name starts with its path.
+func (f *objectFS) readDir(dir string) ([]fs.DirEntry, error) {
+	objects, err := f.store.ListObjectInfo(f.ctx)
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, err
+	}
+
+	prefix := ""
+	if dir != "." {
+		prefix = dir + "/"
+	}
+
+	children := make(map[string]fs.FileInfo)
+	for _, obj := range objects {
+		rest, ok := strings.CutPrefix(obj.Name, prefix)
+		if !ok || !fs.ValidPath(obj.Name) {
+			continue
+		}
+		if child, _, isDir := strings.Cut(rest, "/"); isDir {
+			children[child] = dirInfo(child)
+			continue
+		}
+		if _, ok := children[rest]; ok {
+			continue // a directory of the same name wins
+		}
+		if obj.Link == nil {
+			children[rest] = fileInfo(rest, obj)
+		} else if info, err := f.objectInfo(obj.Name); err == nil {
+			children[rest] = info
+		}
+	}
+	if len(children) == 0 && dir != "." {
+		return nil, fs.ErrNotExist
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, info := range children {
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+type objectFileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func fileInfo(name string, info *ObjectInfo) *objectFileInfo {
+	return &objectFileInfo{name: name, size: int64(info.Size), mode: 0o444, modTime: info.ModTime}
+}
+
+func dirInfo(name string) *objectFileInfo {
+	return &objectFileInfo{name: path.Base(name), mode: fs.ModeDir | 0o555}
+}
+
+func (i *objectFileInfo) Name() string       { return i.name }
+func (i *objectFileInfo) Size() int64        { return i.size }
+func (i *objectFileInfo) Mode() fs.FileMode  { return i.mode }
+func (i *objectFileInfo) ModTime() time.Time { return i.modTime }
+func (i *objectFileInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i *objectFileInfo) Sys() any           { return nil }
+
+// objectFile streams the object's content on the first Read.
+type objectFile struct {
+	fs     *objectFS
+	name   string
+	info   *objectFileInfo
+	r      io.ReadCloser
+	closed bool
+}
+
+func (f *objectFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+
+func (f *objectFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: fs.ErrClosed}
+	}
+	if f.r == nil {
+		r, _, err := f.fs.store.GetObjectStream(f.fs.ctx, f.name)
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		f.r = r
+	}
+	return f.r.Read(p)
+}
+
+func (f *objectFile) Close() error {
+	if f.closed {
+		return &fs.PathError{Op: "close", Path: f.name, Err: fs.ErrClosed}
+	}
+	f.closed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return nil
+}
+
+type objectDir struct {
+	info    *objectFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *objectDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *objectDir) Close() error               { return nil }
+
+func (d *objectDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errors.New("is a directory")}
+}
+
+func (d *objectDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
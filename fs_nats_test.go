@@ -0,0 +1,67 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"slices"
+	"testing"
+	"testing/fstest"
+)
+
+func TestObjectFS(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewObjectStoreProvider(testObj.js, "objects_fs")
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("objects_fs")
+
+	for name, content := range map[string]string{
+		"index.html":        "<h1>home</h1>",
+		"css/site.css":      "body{}",
+		"docs/a/b.txt":      "nested",
+		"docs/empty.txt":    "",
+		"/not/a/valid/path": "hidden",
+	} {
+		if _, err := store.PutObject(ctx, name, []byte(content)); err != nil {
+			t.Fatalf("Error putting %s: %v", name, err)
+		}
+	}
+	if _, err := store.AddLink(ctx, "latest.html", ObjectLink{Name: "index.html"}); err != nil {
+		t.Fatalf("Error adding link: %v", err)
+	}
+	if _, err := store.AddBucketLink(ctx, "self", "objects_fs"); err != nil {
+		t.Fatalf("Error adding bucket link: %v", err)
+	}
+
+	fsys := NewObjectFS(ctx, store)
+	if err := fstest.TestFS(fsys, "index.html", "latest.html", "css/site.css", "docs/a/b.txt", "docs/empty.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatalf("Error reading root: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"css", "docs", "index.html", "latest.html"}; !slices.Equal(names, want) {
+		t.Fatalf("Expected root entries %v, got %v", want, names)
+	}
+
+	data, err := fs.ReadFile(fsys, "latest.html")
+	if err != nil || string(data) != "<h1>home</h1>" {
+		t.Fatalf("Expected content through link, got %q, %v", data, err)
+	}
+	info, err := fs.Stat(fsys, "docs/a/b.txt")
+	if err != nil || info.Size() != 6 || info.ModTime().IsZero() || info.IsDir() {
+		t.Fatalf("Unexpected file info: %+v, %v", info, err)
+	}
+	if _, err := fs.Stat(fsys, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("Expected fs.ErrNotExist, got %v", err)
+	}
+}
@@ -1,6 +1,11 @@
 package natsprovider
 
-import "github.com/nats-io/nats.go"
+import (
+	"context"
+	"io"
+	"io/fs"
+	"time"
+)
 
 type (
 	Provider interface {
@@ -13,14 +18,24 @@ type (
 		KeyValue() (KeyValueProvider, error)
 		ObjectStore() (ObjectStoreProvider, error)
 		Stream() (StreamProvider, error)
-		Config() ConfigProvider // config distribuida con watch
+		Config() (ConfigProvider, error) // config distribuida con watch
+
+		// Drain stops watchers and stream consumers, lets in-flight messages
+		// finish and closes the connection. Without a deadline on ctx the
+		// shutdown timeout set with WithShutdownTimeout applies.
+		Drain(ctx context.Context) error
+		// Close stops everything immediately without waiting for in-flight
+		// messages.
+		Close() error
 	}
 
+	// CoreProvider wraps core NATS messaging. Subscriptions are removed when
+	// their context is done; Request waits until the context deadline.
 	CoreProvider interface {
-		Publish(subject string, msg []byte, headers map[string]string) error
-		Subscribe(subject string, handler MsgHandler) (Unsubscriber, error)
-		QueueSubscribe(subject, queue string, handler MsgHandler) (Unsubscriber, error)
-		Request(subject string, msg []byte, timeoutMs int) (*Message, error)
+		Publish(ctx context.Context, subject string, msg []byte, headers map[string]string) error
+		Subscribe(ctx context.Context, subject string, handler MsgHandler) (Unsubscriber, error)
+		QueueSubscribe(ctx context.Context, subject, queue string, handler MsgHandler) (Unsubscriber, error)
+		Request(ctx context.Context, subject string, msg []byte) (*Message, error)
 	}
 
 	MsgHandler func(msg *Message)
@@ -32,44 +47,169 @@ type (
 		Headers map[string]string
 	}
 
+	// KeyValueProvider wraps a JetStream key-value bucket. A watch stops when
+	// its context is done or on Unwatch.
 	KeyValueProvider interface {
-		Get(key string) (string, error)
-		Set(key, value string) error
-		Delete(key string) error
-		List() ([]string, error)
-		Exists(key string) (bool, error)
-		Watch(key string, cb func(string, string)) error
+		Get(ctx context.Context, key string) (string, error)
+		Set(ctx context.Context, key, value string) error
+		Delete(ctx context.Context, key string) error
+		List(ctx context.Context) ([]string, error)
+		Exists(ctx context.Context, key string) (bool, error)
+		Watch(ctx context.Context, key string, cb func(string, string)) error
 		Unwatch(key string) error
 		Close() error
 	}
 
+	// ConfigProvider is distributed configuration stored in a key-value
+	// bucket under dotted keys such as "app.db.host". Reads are served from a
+	// local copy that a single watcher keeps current.
 	ConfigProvider interface {
-		WatchConfig(key string, cb func(string, string)) error
+		// WatchConfig calls cb with the current values under key first and
+		// then with every change until it is unsubscribed or Close.
+		WatchConfig(key string, cb func(ConfigEvent)) (Unsubscriber, error)
 		GetConfigValue(key string) (string, error)
+		// GetConfigValues returns the values of every key WatchConfig(key)
+		// would report.
+		GetConfigValues(key string) (map[string]string, error)
 		SetConfigValue(key, value string) error
+
+		// History, GetAtRevision and Rollback work on the revisions the
+		// bucket keeps for a key; Snapshot and Restore save and bring back
+		// a whole prefix under a named tag in an object store.
+		History(key string) ([]ConfigRevision, error)
+		GetAtRevision(key string, revision uint64) (string, error)
+		Rollback(key string, revision uint64) error
+		Snapshot(ctx context.Context, objects ObjectStoreProvider, prefix, tag string) (*ConfigSnapshot, error)
+		Restore(ctx context.Context, objects ObjectStoreProvider, tag string, opts ...RestoreOption) (*ConfigRestore, error)
+
+		Close() error
 	}
 
 	ObjectStoreProvider interface {
-		PutObject(name string, data []byte) (*nats.ObjectInfo, error)
-		GetObject(name string) ([]byte, error)
-		DeleteObject(name string) error
-		ListObjects() ([]string, error)
+		PutObject(ctx context.Context, name string, data []byte) (*ObjectInfo, error)
+		// PutObjectStream stores everything read from r under name, chunk
+		// by chunk, without holding the content in memory.
+		PutObjectStream(ctx context.Context, name string, r io.Reader, meta ObjectMeta) (*ObjectInfo, error)
+		GetObject(ctx context.Context, name string) ([]byte, error)
+		// GetObjectStream returns a reader over the object's content. ctx
+		// bounds the whole read, not just the call, and the caller must
+		// close the reader. A digest mismatch is reported by Read at the
+		// end of the content.
+		GetObjectStream(ctx context.Context, name string) (io.ReadCloser, *ObjectInfo, error)
+		// GetObjectInfo and UpdateMeta work on the meta data only and never
+		// transfer the content.
+		GetObjectInfo(ctx context.Context, name string) (*ObjectInfo, error)
+		UpdateMeta(ctx context.Context, name string, meta ObjectMeta) error
+		DeleteObject(ctx context.Context, name string) error
+		ListObjects(ctx context.Context) ([]string, error)
+		// ListObjectInfo is ListObjects with the meta data of every object.
+		ListObjectInfo(ctx context.Context) ([]*ObjectInfo, error)
+
+		// AddLink stores name as a link to target, which may live in another
+		// bucket. Reads through the link return the target's content.
+		AddLink(ctx context.Context, name string, target ObjectLink) (*ObjectInfo, error)
+		// AddBucketLink stores name as a link to a whole bucket.
+		AddBucketLink(ctx context.Context, name, bucket string) (*ObjectInfo, error)
+		ResolveLink(ctx context.Context, name string) (*ObjectInfo, error)
+
+		// Seal makes the bucket read-only. It cannot be undone.
+		Seal(ctx context.Context) error
+		Status(ctx context.Context) (*ObjectStoreStatus, error)
+		// Watch calls cb for every change in the bucket, starting with the
+		// objects already stored unless WithWatchUpdatesOnly is given, until
+		// ctx is done or Close is called.
+		Watch(ctx context.Context, cb func(ObjectEvent), opts ...ObjectWatchOption) error
+		Close() error
 	}
 
+	// ObjectFS is a read-only io/fs view of an object store bucket.
+	ObjectFS interface {
+		fs.ReadDirFS
+		fs.ReadFileFS
+		fs.StatFS
+	}
+
+	// StreamProvider manages JetStream streams. SubscribeToStream binds a
+	// pull consumer to the stream's durable, creating it when missing, and
+	// stops when its context is done or on Unsubscribe.
 	StreamProvider interface {
-		CreateStream(name string, subjects []string) error
-		DeleteStream(name string) error
-		PublishToStream(stream, subject string, msg []byte, headers map[string]string) error
-		SubscribeToStream(stream, durable string, handler MsgHandler) (Unsubscriber, error)
+		CreateStream(ctx context.Context, spec StreamSpec) error
+		// CreateOrUpdateStream creates the stream or brings an existing one in
+		// line with spec, reporting the fields that changed.
+		CreateOrUpdateStream(ctx context.Context, spec StreamSpec) (*StreamUpdate, error)
+		DeleteStream(ctx context.Context, name string) error
+		// PublishToStream publishes and waits for the stream's ack. A non-empty
+		// stream is checked against the stream that stored the message. A
+		// mismatch returns the ack with ErrUnexpectedStream: the message is
+		// stored all the same and must not be published again. Use
+		// WithExpectStream to have the server refuse it instead.
+		PublishToStream(ctx context.Context, stream, subject string, msg []byte, headers map[string]string, opts ...PublishOption) (*PubAck, error)
+		// AsyncPublisher pipelines publishes with at most maxInFlight
+		// messages the client has not settled yet. stream is checked like in
+		// PublishToStream.
+		AsyncPublisher(stream string, maxInFlight int, opts ...AsyncPublisherOption) AsyncPublisher
+		SubscribeToStream(ctx context.Context, stream, durable string, handler StreamHandler, opts ...ConsumeOption) (Unsubscriber, error)
+
+		AddConsumer(ctx context.Context, stream string, spec ConsumerSpec) (*ConsumerInfo, error)
+		UpdateConsumer(ctx context.Context, stream string, spec ConsumerSpec) (*ConsumerInfo, error)
+		ConsumerInfo(ctx context.Context, stream, name string) (*ConsumerInfo, error)
+		ListConsumers(ctx context.Context, stream string) ([]*ConsumerInfo, error)
+		DeleteConsumer(ctx context.Context, stream, name string) error
+
+		GetMessage(ctx context.Context, stream string, seq uint64) (*StoredMessage, error)
+		GetLastMessageForSubject(ctx context.Context, stream, subject string) (*StoredMessage, error)
+		// DeleteMessage removes a single message; erase overwrites its data
+		// on disk as well.
+		DeleteMessage(ctx context.Context, stream string, seq uint64, erase bool) error
+		PurgeStream(ctx context.Context, stream string, opts ...PurgeOption) error
+		// StreamInfo reports stream state. A non-empty subjectsFilter fills
+		// in per-subject message counts.
+		StreamInfo(ctx context.Context, stream, subjectsFilter string) (*StreamInfo, error)
 
-		CreateMirrorStream(name, sourceStream string) error
-		CreateSourceStream(name, sourceSubject string) error
+		// CreateMirrorStream creates a read-only copy of source. spec must not
+		// list subjects.
+		CreateMirrorStream(ctx context.Context, spec StreamSpec, source StreamSource) error
+		// CreateSourceStream creates a stream aggregating every source, in
+		// addition to any subjects listed in spec.
+		CreateSourceStream(ctx context.Context, spec StreamSpec, sources ...StreamSource) error
 	}
 
 	Unsubscriber interface {
 		Unsubscribe() error
 	}
 
+	// FilesystemProvider is a writable file system with os-like semantics.
+	// Errors are *fs.PathError or *os.LinkError as with package os.
+	FilesystemProvider interface {
+		Open(name string) (File, error)
+		Create(name string) (File, error)
+		OpenFile(name string, flag int, perm fs.FileMode) (File, error)
+		Mkdir(name string, perm fs.FileMode) error
+		MkdirAll(name string, perm fs.FileMode) error
+		Remove(name string) error
+		Rename(oldname, newname string) error
+		Stat(name string) (fs.FileInfo, error)
+		Chtimes(name string, atime, mtime time.Time) error
+	}
+
+	// File is an open file or directory of a FilesystemProvider. Writes are
+	// buffered and only reach the server on Sync or Close.
+	File interface {
+		io.Reader
+		io.ReaderAt
+		io.Writer
+		io.WriterAt
+		io.Seeker
+		io.Closer
+		Name() string
+		Stat() (fs.FileInfo, error)
+		Sync() error
+		Truncate(size int64) error
+		WriteString(s string) (int, error)
+		Readdir(count int) ([]fs.FileInfo, error)
+		Readdirnames(n int) ([]string, error)
+	}
+
 	FileProvider interface {
 		GetFile(name string) ([]byte, error)
 		PutFile(name string, data []byte) error
@@ -1,6 +1,7 @@
 package natsprovider
 
 import (
+	"context"
 	"errors"
 	"sync"
 
@@ -13,6 +14,7 @@ type kvProvider struct {
 	storeName string
 	watchers  map[string]nats.KeyWatcher
 	lock      sync.Mutex
+	closed    bool
 }
 
 func NewKeyValueProvider(js nats.JetStreamContext, storeName string) (KeyValueProvider, error) {
@@ -33,33 +35,44 @@ func NewKeyValueProvider(js nats.JetStreamContext, storeName string) (KeyValuePr
 	}, nil
 }
 
-func (kv *kvProvider) Get(key string) (string, error) {
-	e, err := kv.store.Get(key)
+// Get, Set, Delete and Exists use KV calls that take no context: they return
+// once ctx is done, but an abandoned Set or Delete may still be applied.
+func (kv *kvProvider) Get(ctx context.Context, key string) (string, error) {
+	e, err := callContext(ctx, func() (nats.KeyValueEntry, error) {
+		return kv.store.Get(key)
+	})
 	if err != nil {
 		return "", err
 	}
 	return string(e.Value()), nil
 }
 
-func (kv *kvProvider) Set(key, value string) error {
-	_, err := kv.store.PutString(key, value)
+func (kv *kvProvider) Set(ctx context.Context, key, value string) error {
+	_, err := callContext(ctx, func() (uint64, error) {
+		return kv.store.PutString(key, value)
+	})
 	return err
 }
 
-func (kv *kvProvider) Delete(key string) error {
-	return kv.store.Delete(key)
+func (kv *kvProvider) Delete(ctx context.Context, key string) error {
+	_, err := callContext(ctx, func() (struct{}, error) {
+		return struct{}{}, kv.store.Delete(key)
+	})
+	return err
 }
 
-func (kv *kvProvider) List() ([]string, error) {
-	keys, err := kv.store.Keys()
+func (kv *kvProvider) List(ctx context.Context) ([]string, error) {
+	keys, err := kv.store.Keys(nats.Context(ctx))
 	if err != nil {
 		return nil, err
 	}
 	return keys, nil
 }
 
-func (kv *kvProvider) Exists(key string) (bool, error) {
-	_, err := kv.store.Get(key)
+func (kv *kvProvider) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := callContext(ctx, func() (nats.KeyValueEntry, error) {
+		return kv.store.Get(key)
+	})
 	if err != nil {
 		if errors.Is(err, nats.ErrKeyNotFound) {
 			return false, nil
@@ -69,8 +82,18 @@ func (kv *kvProvider) Exists(key string) (bool, error) {
 	return true, nil
 }
 
+// Close stops every active watcher. The bucket itself needs no explicit close.
 func (kv *kvProvider) Close() error {
-	return nil // NATS KV does not require explicit close
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+
+	var errs []error
+	for key, w := range kv.watchers {
+		errs = append(errs, w.Stop())
+		delete(kv.watchers, key)
+	}
+	kv.closed = true
+	return errors.Join(errs...)
 }
 
 func (kv *kvProvider) Open() error {
@@ -92,10 +115,17 @@ func (kv *kvProvider) GetStoreName() string {
 	return kv.storeName
 }
 
-func (kv *kvProvider) Watch(key string, callback func(string, string)) error {
+func (kv *kvProvider) Watch(ctx context.Context, key string, callback func(string, string)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	kv.lock.Lock()
 	defer kv.lock.Unlock()
 
+	if kv.closed {
+		return ErrClosed
+	}
 	if _, ok := kv.watchers[key]; ok {
 		return nil // already watching
 	}
@@ -106,8 +136,12 @@ func (kv *kvProvider) Watch(key string, callback func(string, string)) error {
 	}
 
 	kv.watchers[key] = watcher
+	stop := context.AfterFunc(ctx, func() {
+		kv.removeWatcher(key, watcher)
+	})
 
 	go func() {
+		defer stop()
 		for update := range watcher.Updates() {
 			if update != nil && update.Operation() != nats.KeyValueDelete {
 				callback(update.Key(), string(update.Value()))
@@ -127,3 +161,12 @@ func (kv *kvProvider) Unwatch(key string) error {
 	}
 	return nil
 }
+
+func (kv *kvProvider) removeWatcher(key string, watcher nats.KeyWatcher) {
+	kv.lock.Lock()
+	defer kv.lock.Unlock()
+	if kv.watchers[key] == watcher {
+		delete(kv.watchers, key)
+	}
+	_ = watcher.Stop()
+}
@@ -0,0 +1,78 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// ErrClosed is returned when using a provider after Close or Drain.
+var ErrClosed = errors.New("natsprovider: provider closed")
+
+// consumerRunner is implemented by sub-providers that run background
+// consumers which must be stopped before the connection goes away.
+type consumerRunner interface {
+	stopConsumers()
+	waitConsumers(ctx context.Context) error
+}
+
+func waitGroupContext(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// callContext runs call for the legacy JetStream calls that take no context
+// and returns ctx.Err() as soon as ctx is done. The abandoned call keeps
+// running in the background, so a write may still be applied after its
+// caller gave up.
+func callContext[T any](ctx context.Context, call func() (T, error)) (T, error) {
+	var (
+		result T
+		err    error
+	)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return result, ctxErr
+	}
+	if ctxErr := waitGroupContext(ctx, func() { result, err = call() }); ctxErr != nil {
+		var zero T
+		return zero, ctxErr
+	}
+	return result, err
+}
+
+// drainConn drains nc and waits for it to close, falling back to a hard close
+// once ctx is done.
+func drainConn(ctx context.Context, nc *nats.Conn) error {
+	if nc.IsClosed() {
+		return nil
+	}
+	if err := nc.Drain(); err != nil {
+		nc.Close()
+		return fmt.Errorf("drain connection: %w", err)
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for !nc.IsClosed() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+			return fmt.Errorf("drain connection: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -5,50 +5,161 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math/rand/v2"
 	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
-// SafeWrite tries to write data into NATS KV with CAS retry logic.
-func SafeWrite(kv nats.KeyValue, key string, modifyFn func(current []byte) ([]byte, error)) error {
-	for attempt := 0; attempt < 3; attempt++ {
-		entry, err := kv.Get(key)
-		switch {
-		case errors.Is(err, nats.ErrKeyNotFound):
-			newData, modErr := modifyFn(nil)
-			if modErr != nil {
-				return modErr
-			}
-			_, err = kv.Create(key, newData)
-			if err == nil {
-				return nil
-			}
-			if errors.Is(err, nats.ErrKeyExists) {
-				time.Sleep(50 * time.Millisecond)
-				continue
+// ErrMaxAttempts is returned by SafeWrite when every attempt lost the race
+// against a concurrent writer.
+var ErrMaxAttempts = errors.New("max attempts reached")
+
+const (
+	defaultMaxAttempts    = 10
+	defaultInitialBackoff = 10 * time.Millisecond
+	defaultMaxBackoff     = time.Second
+)
+
+// SafeWriteOption configures SafeWrite and SafeWriteJSON.
+type SafeWriteOption func(*safeWriteOptions)
+
+type safeWriteOptions struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	deleteOnNil    bool
+}
+
+// WithMaxAttempts bounds how many times the value is read, modified and
+// written before giving up with ErrMaxAttempts.
+func WithMaxAttempts(n int) SafeWriteOption {
+	return func(o *safeWriteOptions) {
+		o.maxAttempts = n
+	}
+}
+
+// WithBackoff sets the wait after the first conflict, doubled after every
+// further one up to limit. Each wait is picked at random between zero and the
+// current backoff so that contending writers spread out.
+func WithBackoff(initial, limit time.Duration) SafeWriteOption {
+	return func(o *safeWriteOptions) {
+		o.initialBackoff = initial
+		o.maxBackoff = limit
+	}
+}
+
+// WithDeleteOnNil deletes the key when modifyFn returns nil data, guarded by
+// the revision it read.
+func WithDeleteOnNil() SafeWriteOption {
+	return func(o *safeWriteOptions) {
+		o.deleteOnNil = true
+	}
+}
+
+// SafeWrite updates key with compare-and-set: it reads the current value, nil
+// when the key does not exist, passes it to modifyFn and writes the result only
+// if nobody else wrote the key in between, retrying on conflicts. modifyFn may
+// run several times and must not have side effects. It returns the revision
+// written, or 0 when the key was deleted or left missing.
+func SafeWrite(ctx context.Context, kv nats.KeyValue, key string, modifyFn func(current []byte) ([]byte, error), opts ...SafeWriteOption) (uint64, error) {
+	o := safeWriteOptions{
+		maxAttempts:    defaultMaxAttempts,
+		initialBackoff: defaultInitialBackoff,
+		maxBackoff:     defaultMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	backoff := o.initialBackoff
+	for attempt := 0; attempt < o.maxAttempts; attempt++ {
+		if attempt > 0 {
+			wait := time.Duration(rand.Int64N(int64(backoff) + 1))
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return 0, ctx.Err()
 			}
-			return fmt.Errorf("failed to create key %q: %w", key, err)
+			backoff = min(2*backoff, o.maxBackoff)
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
 
-		case err != nil:
-			return fmt.Errorf("failed to get key %q: %w", key, err)
+		rev, err := safeWriteOnce(kv, key, modifyFn, o.deleteOnNil)
+		if errors.Is(err, nats.ErrKeyExists) {
+			continue // lost the race, retry with the new value
 		}
+		return rev, err
+	}
+	return 0, fmt.Errorf("write key %q: %w", key, ErrMaxAttempts)
+}
 
-		newData, modErr := modifyFn(entry.Value())
-		if modErr != nil {
-			return modErr
+func safeWriteOnce(kv nats.KeyValue, key string, modifyFn func([]byte) ([]byte, error), deleteOnNil bool) (uint64, error) {
+	var current []byte
+	var revision uint64
+
+	entry, err := kv.Get(key)
+	switch {
+	case errors.Is(err, nats.ErrKeyNotFound):
+	case err != nil:
+		return 0, fmt.Errorf("failed to get key %q: %w", key, err)
+	default:
+		current, revision = entry.Value(), entry.Revision()
+	}
+
+	newData, err := modifyFn(current)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case newData == nil && deleteOnNil:
+		if revision == 0 {
+			return 0, nil // nothing to delete
+		}
+		err = kv.Delete(key, nats.LastRevision(revision))
+		if err != nil && !errors.Is(err, nats.ErrKeyExists) {
+			err = fmt.Errorf("failed to delete key %q: %w", key, err)
 		}
+		return 0, err
 
-		if _, err = kv.Update(key, newData, entry.Revision()); err == nil {
-			return nil
+	case revision == 0:
+		rev, err := kv.Create(key, newData)
+		if err != nil && !errors.Is(err, nats.ErrKeyExists) {
+			err = fmt.Errorf("failed to create key %q: %w", key, err)
 		}
-		if errors.Is(err, nats.ErrKeyExists) {
-			time.Sleep(50 * time.Millisecond)
-			continue
+		return rev, err
+
+	default:
+		rev, err := kv.Update(key, newData, revision)
+		if err != nil && !errors.Is(err, nats.ErrKeyExists) {
+			err = fmt.Errorf("failed to update key %q: %w", key, err)
 		}
-		return fmt.Errorf("failed to update key %q: %w", key, err)
+		return rev, err
 	}
-	return fmt.Errorf("max retries reached for key %q", key)
+}
+
+// SafeWriteJSON is SafeWrite for JSON encoded values. current is nil when the
+// key does not exist or is empty; returning nil stores an empty value, or
+// deletes the key with WithDeleteOnNil.
+func SafeWriteJSON[T any](ctx context.Context, kv nats.KeyValue, key string, modifyFn func(current *T) (*T, error), opts ...SafeWriteOption) (uint64, error) {
+	return SafeWrite(ctx, kv, key, func(data []byte) ([]byte, error) {
+		var current *T
+		if len(data) > 0 {
+			current = new(T)
+			if err := json.Unmarshal(data, current); err != nil {
+				return nil, fmt.Errorf("decode key %q: %w", key, err)
+			}
+		}
+
+		next, err := modifyFn(current)
+		if err != nil || next == nil {
+			return nil, err
+		}
+		return json.Marshal(next)
+	}, opts...)
 }
 
 // WatchAndSync watches a KV bucket prefix and runs syncFn on each update. Cancellable with ctx.
@@ -2,8 +2,11 @@ package nats
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -23,8 +26,16 @@ func TestMain(m *testing.M) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	storeDir, err := os.MkdirTemp("", "nats-test-*")
+	if err != nil {
+		log.Fatalf("Error creating store dir: %v", err)
+	}
+	defer os.RemoveAll(storeDir)
+
 	ns, err := server.NewServer(&server.Options{
+		Port:      server.RANDOM_PORT,
 		JetStream: true,
+		StoreDir:  storeDir,
 		Debug:     true,
 		Trace:     true,
 	})
@@ -51,7 +62,10 @@ func TestMain(m *testing.M) {
 	testObj.ctx = ctx
 	testObj.js = js
 
-	os.Exit(m.Run())
+	code := m.Run()
+	ns.Shutdown()
+	os.RemoveAll(storeDir)
+	os.Exit(code)
 }
 
 func TestWatchAndSync(t *testing.T) {
@@ -72,7 +86,7 @@ func TestWatchAndSync(t *testing.T) {
 	}
 
 	key := uuid.NewString()
-	if err = SafeWrite(kv, key, func(current []byte) ([]byte, error) {
+	if _, err = SafeWrite(testObj.ctx, kv, key, func(current []byte) ([]byte, error) {
 		return []byte("hello world"), nil
 	}); err != nil {
 		t.Fatalf("Error writing to key: %v", err)
@@ -81,3 +95,125 @@ func TestWatchAndSync(t *testing.T) {
 	// Allow some time for WatchAndSync to pick up the change
 	time.Sleep(200 * time.Millisecond)
 }
+
+func newTestKV(t *testing.T) nats.KeyValue {
+	t.Helper()
+	bucket := "TEST_" + strings.ReplaceAll(uuid.NewString(), "-", "")
+	kv, err := testObj.js.CreateKeyValue(&nats.KeyValueConfig{Bucket: bucket, History: 5})
+	if err != nil {
+		t.Fatalf("Error creating key-value store: %v", err)
+	}
+	t.Cleanup(func() { _ = testObj.js.DeleteKeyValue(bucket) })
+	return kv
+}
+
+func TestSafeWriteContention(t *testing.T) {
+	kv := newTestKV(t)
+
+	type counter struct {
+		Count int `json:"count"`
+	}
+
+	const workers, increments = 8, 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				_, err := SafeWriteJSON(testObj.ctx, kv, "hits", func(c *counter) (*counter, error) {
+					if c == nil {
+						c = &counter{}
+					}
+					c.Count++
+					return c, nil
+				}, WithMaxAttempts(1000), WithBackoff(time.Millisecond, 20*time.Millisecond))
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Error incrementing counter: %v", err)
+	}
+
+	rev, err := SafeWriteJSON(testObj.ctx, kv, "hits", func(c *counter) (*counter, error) {
+		if c.Count != workers*increments {
+			t.Errorf("Expected count %d, got %d", workers*increments, c.Count)
+		}
+		return c, nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading counter: %v", err)
+	}
+	entry, err := kv.Get("hits")
+	if err != nil {
+		t.Fatalf("Error getting counter: %v", err)
+	}
+	if entry.Revision() != rev {
+		t.Errorf("Expected revision %d, got %d", entry.Revision(), rev)
+	}
+}
+
+func TestSafeWriteDeleteOnNil(t *testing.T) {
+	kv := newTestKV(t)
+
+	if _, err := kv.Put("key", []byte("value")); err != nil {
+		t.Fatalf("Error putting key: %v", err)
+	}
+	rev, err := SafeWrite(testObj.ctx, kv, "key", func(current []byte) ([]byte, error) {
+		return nil, nil
+	}, WithDeleteOnNil())
+	if err != nil || rev != 0 {
+		t.Fatalf("Expected delete, got revision %d, error %v", rev, err)
+	}
+	if _, err := kv.Get("key"); !errors.Is(err, nats.ErrKeyNotFound) {
+		t.Fatalf("Expected key to be deleted, got %v", err)
+	}
+
+	// A deleted key reads as missing and can be created again.
+	if _, err := SafeWrite(testObj.ctx, kv, "key", func(current []byte) ([]byte, error) {
+		if current != nil {
+			t.Errorf("Expected no current value, got %q", current)
+		}
+		return []byte("again"), nil
+	}); err != nil {
+		t.Fatalf("Error recreating key: %v", err)
+	}
+}
+
+func TestSafeWriteGivesUp(t *testing.T) {
+	kv := newTestKV(t)
+
+	// Every modify races a write of its own, so no attempt can succeed.
+	conflict := func(current []byte) ([]byte, error) {
+		if _, err := kv.Put("key", []byte("other")); err != nil {
+			return nil, err
+		}
+		return []byte("mine"), nil
+	}
+
+	_, err := SafeWrite(testObj.ctx, kv, "key", conflict, WithMaxAttempts(3), WithBackoff(time.Millisecond, time.Millisecond))
+	if !errors.Is(err, ErrMaxAttempts) {
+		t.Fatalf("Expected ErrMaxAttempts, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(testObj.ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = SafeWrite(ctx, kv, "key", conflict, WithMaxAttempts(1000), WithBackoff(10*time.Millisecond, 10*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	_, err = SafeWrite(testObj.ctx, kv, "key", func([]byte) ([]byte, error) {
+		return nil, errors.New("boom")
+	})
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("Expected modify error, got %v", err)
+	}
+}
@@ -2,21 +2,154 @@ package natsprovider
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/nats-io/nats.go"
 )
 
+// ObjectMeta is the caller-controlled part of an object's meta data.
+type ObjectMeta struct {
+	Description string
+	Headers     map[string]string
+	Metadata    map[string]string
+	// ChunkSize is the size of the messages the object is split into. Zero
+	// uses the server default of 128KiB. It only applies when the object is
+	// written and is ignored by UpdateMeta.
+	ChunkSize uint32
+}
+
+func (m ObjectMeta) config(name string) *nats.ObjectMeta {
+	meta := &nats.ObjectMeta{
+		Name:        name,
+		Description: m.Description,
+		Metadata:    m.Metadata,
+	}
+	if len(m.Headers) > 0 {
+		meta.Headers = make(nats.Header, len(m.Headers))
+		for k, v := range m.Headers {
+			meta.Headers.Set(k, v)
+		}
+	}
+	if m.ChunkSize > 0 {
+		meta.Opts = &nats.ObjectMetaOptions{ChunkSize: m.ChunkSize}
+	}
+	return meta
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Name string
+	ObjectMeta
+	Bucket  string
+	NUID    string
+	Size    uint64
+	ModTime time.Time
+	Chunks  uint32
+	// Digest is the SHA-256 of the content, e.g. "SHA-256=<base64url>".
+	Digest  string
+	Deleted bool
+	// Link is set when the object points at another object or bucket.
+	Link *ObjectLink
+}
+
+// ObjectLink points at an object, or at a whole bucket when Name is empty.
+type ObjectLink struct {
+	Bucket string
+	Name   string
+}
+
+// ObjectOp is the kind of change reported by an ObjectEvent.
+type ObjectOp int
+
+const (
+	ObjectPut ObjectOp = iota
+	ObjectDelete
+)
+
+// ObjectEvent is delivered to Watch callbacks for every put, meta data update
+// and delete in the bucket.
+type ObjectEvent struct {
+	Op   ObjectOp
+	Info *ObjectInfo
+}
+
+// ObjectStoreStatus reports the state of an object store bucket.
+type ObjectStoreStatus struct {
+	Bucket      string
+	Description string
+	TTL         time.Duration
+	Storage     StorageType
+	Replicas    int
+	Sealed      bool
+	Compressed  bool
+	Size        uint64
+	// BackingStream is the name of the JetStream stream holding the bucket.
+	BackingStream string
+	Metadata      map[string]string
+}
+
+// ObjectWatchOption configures Watch.
+type ObjectWatchOption func(*objectWatchOptions)
+
+type objectWatchOptions struct {
+	updatesOnly bool
+}
+
+// WithWatchUpdatesOnly skips the objects already in the bucket and only
+// reports changes made after Watch returns.
+func WithWatchUpdatesOnly() ObjectWatchOption {
+	return func(o *objectWatchOptions) {
+		o.updatesOnly = true
+	}
+}
+
+func newObjectInfo(info *nats.ObjectInfo) *ObjectInfo {
+	o := &ObjectInfo{
+		Name: info.Name,
+		ObjectMeta: ObjectMeta{
+			Description: info.Description,
+			Metadata:    info.Metadata,
+		},
+		Bucket:  info.Bucket,
+		NUID:    info.NUID,
+		Size:    info.Size,
+		ModTime: info.ModTime,
+		Chunks:  info.Chunks,
+		Digest:  info.Digest,
+		Deleted: info.Deleted,
+	}
+	if len(info.Headers) > 0 {
+		o.Headers = make(map[string]string, len(info.Headers))
+		for k := range info.Headers {
+			o.Headers[k] = info.Headers.Get(k)
+		}
+	}
+	if info.Opts != nil {
+		o.ChunkSize = info.Opts.ChunkSize
+		if link := info.Opts.Link; link != nil {
+			o.Link = &ObjectLink{Bucket: link.Bucket, Name: link.Name}
+		}
+	}
+	return o
+}
+
 type objectStoreProvider struct {
+	js        nats.JetStreamContext
 	store     nats.ObjectStore
 	storeName string
+	watchers  map[nats.ObjectWatcher]struct{}
+	lock      sync.Mutex
+	closed    bool
 }
 
 func NewObjectStoreProvider(js nats.JetStreamContext, storeName string) (ObjectStoreProvider, error) {
 	store, err := js.ObjectStore(storeName)
-	if errors.Is(err, nats.ErrBucketNotFound) {
+	if errors.Is(err, nats.ErrBucketNotFound) || errors.Is(err, nats.ErrStreamNotFound) {
 		store, err = js.CreateObjectStore(&nats.ObjectStoreConfig{
 			Bucket: storeName,
 		})
@@ -25,21 +158,31 @@ func NewObjectStoreProvider(js nats.JetStreamContext, storeName string) (ObjectS
 		return nil, err
 	}
 	return &objectStoreProvider{
+		js:        js,
 		store:     store,
 		storeName: storeName,
+		watchers:  make(map[nats.ObjectWatcher]struct{}),
 	}, nil
 }
 
-func (o *objectStoreProvider) PutObject(name string, data []byte) (*nats.ObjectInfo, error) {
-	return o.store.Put(&nats.ObjectMeta{Name: name}, bytes.NewReader(data))
+func (o *objectStoreProvider) PutObject(ctx context.Context, name string, data []byte) (*ObjectInfo, error) {
+	return o.PutObjectStream(ctx, name, bytes.NewReader(data), ObjectMeta{})
+}
+
+func (o *objectStoreProvider) PutObjectStream(ctx context.Context, name string, r io.Reader, meta ObjectMeta) (*ObjectInfo, error) {
+	info, err := o.store.Put(meta.config(name), r, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(info), nil
 }
 
-func (o *objectStoreProvider) GetObject(name string) ([]byte, error) {
-	reader, err := o.store.Get(name)
+func (o *objectStoreProvider) GetObject(ctx context.Context, name string) ([]byte, error) {
+	reader, _, err := o.GetObjectStream(ctx, name)
 	if err != nil {
 		return nil, err
 	}
-	defer func(reader nats.ObjectResult) {
+	defer func(reader io.ReadCloser) {
 		if err := reader.Close(); err != nil {
 			log.Printf("Error closing object reader: %v", err)
 		}
@@ -47,13 +190,127 @@ func (o *objectStoreProvider) GetObject(name string) ([]byte, error) {
 	return io.ReadAll(reader)
 }
 
-func (o *objectStoreProvider) DeleteObject(name string) error {
-	return o.store.Delete(name)
+// GetObjectStream follows object links itself rather than leaving it to the
+// client, which drops ctx when reading through a link.
+func (o *objectStoreProvider) GetObjectStream(ctx context.Context, name string) (io.ReadCloser, *ObjectInfo, error) {
+	store, name, err := o.resolve(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := store.Get(name, nats.Context(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := result.Info()
+	if err != nil {
+		_ = result.Close()
+		return nil, nil, err
+	}
+	return result, newObjectInfo(info), nil
+}
+
+func (o *objectStoreProvider) GetObjectInfo(ctx context.Context, name string) (*ObjectInfo, error) {
+	info, err := o.store.GetInfo(name, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(info), nil
 }
 
-func (o *objectStoreProvider) ListObjects() ([]string, error) {
+// ResolveLink returns the object a link points at. Objects that are not
+// links resolve to themselves; bucket links fail with nats.ErrCantGetBucket.
+func (o *objectStoreProvider) ResolveLink(ctx context.Context, name string) (*ObjectInfo, error) {
+	store, name, err := o.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := store.GetInfo(name, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(info), nil
+}
+
+func (o *objectStoreProvider) resolve(ctx context.Context, name string) (nats.ObjectStore, string, error) {
+	info, err := o.store.GetInfo(name, nats.Context(ctx))
+	if err != nil {
+		return nil, "", err
+	}
+	if info.Opts == nil || info.Opts.Link == nil {
+		return o.store, name, nil
+	}
+
+	link := info.Opts.Link
+	if link.Name == "" {
+		return nil, "", nats.ErrCantGetBucket
+	}
+	store, err := o.bucket(link.Bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	return store, link.Name, nil
+}
+
+func (o *objectStoreProvider) bucket(name string) (nats.ObjectStore, error) {
+	if name == "" || name == o.storeName {
+		return o.store, nil
+	}
+	return o.js.ObjectStore(name)
+}
+
+func (o *objectStoreProvider) AddLink(ctx context.Context, name string, target ObjectLink) (*ObjectInfo, error) {
+	if target.Name == "" {
+		return nil, nats.ErrObjectRequired
+	}
+	store, err := o.bucket(target.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := store.GetInfo(target.Name, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	info, err := o.store.AddLink(name, obj)
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(info), nil
+}
+
+// AddBucketLink, UpdateMeta, DeleteObject, Seal and Status use object store
+// calls that take no context: they return once ctx is done, but an abandoned
+// write may still be applied.
+func (o *objectStoreProvider) AddBucketLink(ctx context.Context, name, bucket string) (*ObjectInfo, error) {
+	info, err := callContext(ctx, func() (*nats.ObjectInfo, error) {
+		store, err := o.bucket(bucket)
+		if err != nil {
+			return nil, err
+		}
+		return o.store.AddBucketLink(name, store)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newObjectInfo(info), nil
+}
+
+func (o *objectStoreProvider) UpdateMeta(ctx context.Context, name string, meta ObjectMeta) error {
+	_, err := callContext(ctx, func() (struct{}, error) {
+		return struct{}{}, o.store.UpdateMeta(name, meta.config(name))
+	})
+	return err
+}
+
+func (o *objectStoreProvider) DeleteObject(ctx context.Context, name string) error {
+	_, err := callContext(ctx, func() (struct{}, error) {
+		return struct{}{}, o.store.Delete(name)
+	})
+	return err
+}
+
+func (o *objectStoreProvider) ListObjects(ctx context.Context) ([]string, error) {
 	var names []string
-	objects, err := o.store.List()
+	objects, err := o.store.List(nats.Context(ctx))
 	if err != nil {
 		return nil, err
 	}
@@ -64,3 +321,120 @@ func (o *objectStoreProvider) ListObjects() ([]string, error) {
 	}
 	return names, nil
 }
+
+func (o *objectStoreProvider) ListObjectInfo(ctx context.Context) ([]*ObjectInfo, error) {
+	objects, err := o.store.List(nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]*ObjectInfo, 0, len(objects))
+	for _, object := range objects {
+		if object != nil {
+			infos = append(infos, newObjectInfo(object))
+		}
+	}
+	return infos, nil
+}
+
+func (o *objectStoreProvider) Seal(ctx context.Context) error {
+	_, err := callContext(ctx, func() (struct{}, error) {
+		return struct{}{}, o.store.Seal()
+	})
+	return err
+}
+
+func (o *objectStoreProvider) Status(ctx context.Context) (*ObjectStoreStatus, error) {
+	status, err := callContext(ctx, o.store.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &ObjectStoreStatus{
+		Bucket:      status.Bucket(),
+		Description: status.Description(),
+		TTL:         status.TTL(),
+		Replicas:    status.Replicas(),
+		Sealed:      status.Sealed(),
+		Compressed:  status.IsCompressed(),
+		Size:        status.Size(),
+		Metadata:    status.Metadata(),
+	}
+	if bucketStatus, ok := status.(*nats.ObjectBucketStatus); ok {
+		s.BackingStream = bucketStatus.StreamInfo().Config.Name
+	}
+	for storage, natsStorage := range storageTypes {
+		if natsStorage == status.Storage() {
+			s.Storage = storage
+		}
+	}
+	return s, nil
+}
+
+func (o *objectStoreProvider) Watch(ctx context.Context, cb func(ObjectEvent), opts ...ObjectWatchOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var wo objectWatchOptions
+	for _, opt := range opts {
+		opt(&wo)
+	}
+	var natsOpts []nats.WatchOpt
+	if wo.updatesOnly {
+		natsOpts = append(natsOpts, nats.UpdatesOnly())
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.closed {
+		return ErrClosed
+	}
+
+	watcher, err := o.store.Watch(natsOpts...)
+	if err != nil {
+		return err
+	}
+
+	o.watchers[watcher] = struct{}{}
+	stop := context.AfterFunc(ctx, func() {
+		o.removeWatcher(watcher)
+	})
+
+	go func() {
+		defer stop()
+		for info := range watcher.Updates() {
+			if info == nil {
+				continue // end of the initial replay
+			}
+			event := ObjectEvent{Op: ObjectPut, Info: newObjectInfo(info)}
+			if info.Deleted {
+				event.Op = ObjectDelete
+			}
+			cb(event)
+		}
+	}()
+
+	return nil
+}
+
+func (o *objectStoreProvider) removeWatcher(watcher nats.ObjectWatcher) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	delete(o.watchers, watcher)
+	_ = watcher.Stop()
+}
+
+// Close stops every active watcher. The bucket itself needs no explicit close.
+func (o *objectStoreProvider) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var errs []error
+	for w := range o.watchers {
+		errs = append(errs, w.Stop())
+		delete(o.watchers, w)
+	}
+	o.closed = true
+	return errors.Join(errs...)
+}
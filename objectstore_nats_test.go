@@ -0,0 +1,166 @@
+package natsprovider
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestObjectStoreStreaming(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewObjectStoreProvider(testObj.js, "objects_stream")
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("objects_stream")
+
+	const size = 1 << 20
+	written := sha256.New()
+	src := io.TeeReader(io.LimitReader(rand.New(rand.NewSource(1)), size), written)
+
+	info, err := store.PutObjectStream(ctx, "artifact.bin", src, ObjectMeta{
+		Description: "build artifact",
+		Headers:     map[string]string{"Content-Type": "application/octet-stream"},
+		Metadata:    map[string]string{"commit": "abc123"},
+		ChunkSize:   64 << 10,
+	})
+	if err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+	if info.Size != size || info.Chunks != 16 || info.ChunkSize != 64<<10 {
+		t.Fatalf("Unexpected object info: size %d, chunks %d, chunk size %d", info.Size, info.Chunks, info.ChunkSize)
+	}
+
+	reader, got, err := store.GetObjectStream(ctx, "artifact.bin")
+	if err != nil {
+		t.Fatalf("Error getting object: %v", err)
+	}
+	read := sha256.New()
+	if _, err := io.Copy(read, reader); err != nil {
+		t.Fatalf("Error reading object: %v", err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatalf("Error closing object: %v", err)
+	}
+	if string(read.Sum(nil)) != string(written.Sum(nil)) {
+		t.Fatal("Read content differs from written content")
+	}
+	if got.Description != "build artifact" || got.Headers["Content-Type"] != "application/octet-stream" || got.Metadata["commit"] != "abc123" {
+		t.Fatalf("Unexpected meta data: %+v", got.ObjectMeta)
+	}
+
+	if err := store.UpdateMeta(ctx, "artifact.bin", ObjectMeta{
+		Description: "release artifact",
+		Metadata:    map[string]string{"commit": "abc123", "tag": "v1.0.0"},
+	}); err != nil {
+		t.Fatalf("Error updating meta: %v", err)
+	}
+	info, err = store.GetObjectInfo(ctx, "artifact.bin")
+	if err != nil {
+		t.Fatalf("Error getting object info: %v", err)
+	}
+	if info.Description != "release artifact" || info.Metadata["tag"] != "v1.0.0" || len(info.Headers) != 0 {
+		t.Fatalf("Meta data not updated: %+v", info.ObjectMeta)
+	}
+	if info.Size != size || info.Digest != got.Digest {
+		t.Fatalf("UpdateMeta changed the content: %+v", info)
+	}
+
+	if _, err := store.GetObjectInfo(ctx, "missing.bin"); !errors.Is(err, nats.ErrObjectNotFound) {
+		t.Fatalf("Expected ErrObjectNotFound, got %v", err)
+	}
+}
+
+func TestObjectStoreLinks(t *testing.T) {
+	ctx := context.Background()
+
+	builds, err := NewObjectStoreProvider(testObj.js, "objects_builds")
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("objects_builds")
+	releases, err := NewObjectStoreProvider(testObj.js, "objects_releases")
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("objects_releases")
+	defer releases.Close()
+
+	events := make(chan ObjectEvent, 8)
+	if err := releases.Watch(ctx, func(e ObjectEvent) { events <- e }, WithWatchUpdatesOnly()); err != nil {
+		t.Fatalf("Error watching bucket: %v", err)
+	}
+
+	if _, err := builds.PutObject(ctx, "app-1234", []byte("binary")); err != nil {
+		t.Fatalf("Error putting object: %v", err)
+	}
+	if _, err := builds.AddLink(ctx, "app-latest", ObjectLink{Name: "app-1234"}); err != nil {
+		t.Fatalf("Error adding link: %v", err)
+	}
+	link, err := releases.AddLink(ctx, "app-v1", ObjectLink{Bucket: "objects_builds", Name: "app-1234"})
+	if err != nil {
+		t.Fatalf("Error adding cross-bucket link: %v", err)
+	}
+	if link.Link == nil || link.Link.Bucket != "objects_builds" || link.Link.Name != "app-1234" {
+		t.Fatalf("Unexpected link: %+v", link.Link)
+	}
+	if _, err := releases.AddBucketLink(ctx, "all-builds", "objects_builds"); err != nil {
+		t.Fatalf("Error adding bucket link: %v", err)
+	}
+
+	for _, tc := range []struct {
+		store ObjectStoreProvider
+		name  string
+	}{{builds, "app-latest"}, {releases, "app-v1"}} {
+		data, err := tc.store.GetObject(ctx, tc.name)
+		if err != nil || string(data) != "binary" {
+			t.Fatalf("Expected content through link %s, got %q, %v", tc.name, data, err)
+		}
+		target, err := tc.store.ResolveLink(ctx, tc.name)
+		if err != nil || target.Name != "app-1234" || target.Bucket != "objects_builds" {
+			t.Fatalf("Unexpected resolved link %s: %+v, %v", tc.name, target, err)
+		}
+	}
+	if _, err := releases.ResolveLink(ctx, "all-builds"); !errors.Is(err, nats.ErrCantGetBucket) {
+		t.Fatalf("Expected ErrCantGetBucket, got %v", err)
+	}
+
+	if err := releases.DeleteObject(ctx, "all-builds"); err != nil {
+		t.Fatalf("Error deleting link: %v", err)
+	}
+	for _, want := range []struct {
+		op   ObjectOp
+		name string
+	}{{ObjectPut, "app-v1"}, {ObjectPut, "all-builds"}, {ObjectDelete, "all-builds"}} {
+		select {
+		case e := <-events:
+			if e.Op != want.op || e.Info.Name != want.name {
+				t.Fatalf("Expected event %v %s, got %v %s", want.op, want.name, e.Op, e.Info.Name)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for event %v %s", want.op, want.name)
+		}
+	}
+
+	if err := releases.Seal(ctx); err != nil {
+		t.Fatalf("Error sealing bucket: %v", err)
+	}
+	status, err := releases.Status(ctx)
+	if err != nil {
+		t.Fatalf("Error getting status: %v", err)
+	}
+	if !status.Sealed || status.Bucket != "objects_releases" || status.BackingStream != "OBJ_objects_releases" ||
+		status.Replicas != 1 || status.Storage != FileStorage || status.Size == 0 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+	if _, err := releases.PutObject(ctx, "late", []byte("x")); err == nil {
+		t.Fatal("Expected error putting into a sealed bucket")
+	}
+}
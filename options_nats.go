@@ -0,0 +1,116 @@
+package natsprovider
+
+import (
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	defaultKVBucket        = "natsprovider_kv"
+	defaultObjectStore     = "natsprovider_objects"
+	defaultConfigBucket    = "natsprovider_config"
+	defaultShutdownTimeout = 30 * time.Second
+)
+
+// Option configures a NATSProvider built by NewNATSProvider.
+type Option func(*options)
+
+type options struct {
+	natsOpts    []nats.Option
+	auth        []Authenticator
+	kvBucket    string
+	objBucket   string
+	cfgBucket   string
+	cfgOpts     []ConfigOption
+	streams     []StreamSpec
+	description string
+	shutdown    time.Duration
+}
+
+func (o *options) connectOptions() ([]nats.Option, error) {
+	natsOpts := append([]nats.Option{nats.DrainTimeout(o.shutdown)}, o.natsOpts...)
+	for _, auth := range o.auth {
+		authOpts, err := auth.Options()
+		if err != nil {
+			return nil, err
+		}
+		natsOpts = append(natsOpts, authOpts...)
+	}
+	return natsOpts, nil
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		description: "NATS and JetStream Provider",
+		shutdown:    defaultShutdownTimeout,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithKVBucket sets the key-value bucket used by KeyValue. The bucket is
+// created eagerly when the provider is built.
+func WithKVBucket(bucket string) Option {
+	return func(o *options) {
+		o.kvBucket = bucket
+	}
+}
+
+// WithObjectStore sets the object store bucket used by ObjectStore. The bucket
+// is created eagerly when the provider is built.
+func WithObjectStore(bucket string) Option {
+	return func(o *options) {
+		o.objBucket = bucket
+	}
+}
+
+// WithConfigBucket sets the key-value bucket used by Config. The bucket is
+// created eagerly when the provider is built.
+func WithConfigBucket(bucket string) Option {
+	return func(o *options) {
+		o.cfgBucket = bucket
+	}
+}
+
+// WithConfigOptions configures the provider returned by Config, e.g. with
+// WithConfigSchema.
+func WithConfigOptions(opts ...ConfigOption) Option {
+	return func(o *options) {
+		o.cfgOpts = append(o.cfgOpts, opts...)
+	}
+}
+
+// WithStreams declares streams that must exist once the provider is built.
+// Existing streams are updated to match.
+func WithStreams(streams ...StreamSpec) Option {
+	return func(o *options) {
+		o.streams = append(o.streams, streams...)
+	}
+}
+
+// WithAuth authenticates the connection with the given authenticators.
+// Several may be combined, e.g. TLSAuth together with CredsFile.
+func WithAuth(auth ...Authenticator) Option {
+	return func(o *options) {
+		o.auth = append(o.auth, auth...)
+		o.description = "NATS and JetStream Provider with Auth"
+	}
+}
+
+// WithShutdownTimeout bounds how long Drain waits for in-flight messages when
+// its context carries no deadline.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.shutdown = timeout
+	}
+}
+
+// WithNATSOptions passes raw connection options through to nats.Connect.
+func WithNATSOptions(opts ...nats.Option) Option {
+	return func(o *options) {
+		o.natsOpts = append(o.natsOpts, opts...)
+	}
+}
@@ -1,6 +1,14 @@
 package natsprovider
 
-import "github.com/nats-io/nats.go"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
 
 type NATSProvider struct {
 	name        string
@@ -8,46 +16,90 @@ type NATSProvider struct {
 	description string
 	nc          *nats.Conn
 	js          nats.JetStreamContext
+	opts        *options
 	core        CoreProvider
 	kv          KeyValueProvider
 	objStore    ObjectStoreProvider
 	stream      StreamProvider
 	config      ConfigProvider
+	lock        sync.Mutex
 }
 
-func NewNATSProvider(url string) (Provider, error) {
-	nc, err := nats.Connect(url)
+// NewNATSProvider connects to url and builds every sub-provider. Buckets set
+// through WithKVBucket and WithObjectStore are created up front; the others
+// are created on first use with default bucket names.
+func NewNATSProvider(url string, opts ...Option) (Provider, error) {
+	o := newOptions(opts...)
+
+	natsOpts, err := o.connectOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	nc, err := nats.Connect(url, natsOpts...)
 	if err != nil {
 		return nil, err
 	}
 	js, err := nc.JetStream()
 	if err != nil {
+		nc.Close()
 		return nil, err
 	}
 
 	p := &NATSProvider{
 		name:        "nats",
 		version:     "1.0.0",
-		description: "NATS and JetStream Provider",
+		description: o.description,
 		nc:          nc,
 		js:          js,
+		opts:        o,
+		core:        NewCoreProvider(nc),
+		stream:      NewStreamProvider(js),
 	}
 
-	// Acá inicializarías los subproviders con la conexión nc y js
-	// p.core = NewCoreProvider(nc)
-	// p.kv = NewKeyValueProvider(js)
-	// p.objStore = NewObjectStoreProvider(js)
-	// p.stream = NewStreamProvider(js)
-	// p.config = NewConfigProvider(p.kv)
+	if err := p.init(); err != nil {
+		nc.Close()
+		return nil, err
+	}
 
 	return p, nil
 }
 
+func (p *NATSProvider) init() error {
+	for _, spec := range p.opts.streams {
+		if _, err := p.stream.CreateOrUpdateStream(context.Background(), spec); err != nil {
+			return fmt.Errorf("stream %q: %w", spec.Name, err)
+		}
+	}
+
+	if p.opts.kvBucket != "" {
+		if _, err := p.KeyValue(); err != nil {
+			return err
+		}
+	}
+	if p.opts.objBucket != "" {
+		if _, err := p.ObjectStore(); err != nil {
+			return err
+		}
+	}
+	if p.opts.cfgBucket != "" {
+		if _, err := p.Config(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *NATSProvider) GetName() string        { return p.name }
 func (p *NATSProvider) GetVersion() string     { return p.version }
 func (p *NATSProvider) GetDescription() string { return p.description }
 func (p *NATSProvider) GetConfig() map[string]any {
-	return map[string]any{"url": p.nc.ConnectedUrl()}
+	return map[string]any{
+		"url":          p.nc.ConnectedUrl(),
+		"kv_bucket":    p.kvBucket(),
+		"object_store": p.objBucket(),
+		"config":       p.cfgBucket(),
+	}
 }
 
 func (p *NATSProvider) Core() CoreProvider {
@@ -55,10 +107,30 @@ func (p *NATSProvider) Core() CoreProvider {
 }
 
 func (p *NATSProvider) KeyValue() (KeyValueProvider, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.kv == nil {
+		kv, err := NewKeyValueProvider(p.js, p.kvBucket())
+		if err != nil {
+			return nil, err
+		}
+		p.kv = kv
+	}
 	return p.kv, nil
 }
 
 func (p *NATSProvider) ObjectStore() (ObjectStoreProvider, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.objStore == nil {
+		objStore, err := NewObjectStoreProvider(p.js, p.objBucket())
+		if err != nil {
+			return nil, err
+		}
+		p.objStore = objStore
+	}
 	return p.objStore, nil
 }
 
@@ -66,6 +138,88 @@ func (p *NATSProvider) Stream() (StreamProvider, error) {
 	return p.stream, nil
 }
 
-func (p *NATSProvider) Config() ConfigProvider {
-	return p.config
+func (p *NATSProvider) Config() (ConfigProvider, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.config == nil {
+		opts := append(slices.Clone(p.opts.cfgOpts), withConfigConn(p.nc))
+		config, err := NewConfigProvider(p.js, p.cfgBucket(), opts...)
+		if err != nil {
+			return nil, err
+		}
+		p.config = config
+	}
+	return p.config, nil
+}
+
+func (p *NATSProvider) Drain(ctx context.Context) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.opts.shutdown)
+		defer cancel()
+	}
+
+	errs := p.stopAll()
+	if runner, ok := p.stream.(consumerRunner); ok {
+		if err := runner.waitConsumers(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("stream consumers: %w", err))
+		}
+	}
+	errs = append(errs, drainConn(ctx, p.nc))
+	return errors.Join(errs...)
+}
+
+func (p *NATSProvider) Close() error {
+	errs := p.stopAll()
+	p.nc.Close()
+	return errors.Join(errs...)
+}
+
+// stopAll stops every watcher and background consumer owned by the provider.
+func (p *NATSProvider) stopAll() []error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var errs []error
+	if p.kv != nil {
+		if err := p.kv.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("key-value: %w", err))
+		}
+	}
+	if p.objStore != nil {
+		if err := p.objStore.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("object store: %w", err))
+		}
+	}
+	if p.config != nil {
+		if err := p.config.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("config: %w", err))
+		}
+	}
+	if runner, ok := p.stream.(consumerRunner); ok {
+		runner.stopConsumers()
+	}
+	return errs
+}
+
+func (p *NATSProvider) kvBucket() string {
+	if p.opts.kvBucket != "" {
+		return p.opts.kvBucket
+	}
+	return defaultKVBucket
+}
+
+func (p *NATSProvider) objBucket() string {
+	if p.opts.objBucket != "" {
+		return p.opts.objBucket
+	}
+	return defaultObjectStore
+}
+
+func (p *NATSProvider) cfgBucket() string {
+	if p.opts.cfgBucket != "" {
+		return p.opts.cfgBucket
+	}
+	return defaultConfigBucket
 }
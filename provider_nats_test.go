@@ -2,6 +2,7 @@ package natsprovider
 
 import (
 	"context"
+	"errors"
 	"log"
 	"os"
 	"testing"
@@ -16,14 +17,23 @@ var testObj testStruct
 type testStruct struct {
 	js  nats.JetStreamContext
 	ctx context.Context
+	url string
 }
 
 func TestMain(m *testing.M) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	storeDir, err := os.MkdirTemp("", "natsprovider")
+	if err != nil {
+		log.Fatalf("Error creating store dir: %v", err)
+	}
+	defer os.RemoveAll(storeDir)
+
 	ns, err := server.NewServer(&server.Options{
+		Port:      server.RANDOM_PORT,
 		JetStream: true,
+		StoreDir:  storeDir,
 		Debug:     true,
 		Trace:     true,
 	})
@@ -49,6 +59,173 @@ func TestMain(m *testing.M) {
 
 	testObj.ctx = ctx
 	testObj.js = js
+	testObj.url = ns.ClientURL()
+
+	code := m.Run()
+	ns.Shutdown()
+	os.RemoveAll(storeDir)
+	os.Exit(code)
+}
+
+func TestNewNATSProvider(t *testing.T) {
+	p, err := NewNATSProvider(testObj.url,
+		WithKVBucket("provider_kv"),
+		WithStreams(StreamSpec{Name: "PROVIDER", Subjects: []string{"provider.>"}}),
+	)
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+
+	if p.Core() == nil {
+		t.Fatal("Core provider is nil")
+	}
+	if _, err := testObj.js.StreamInfo("PROVIDER"); err != nil {
+		t.Fatalf("Stream was not created: %v", err)
+	}
+
+	kv, err := p.KeyValue()
+	if err != nil || kv == nil {
+		t.Fatalf("Error getting key-value provider: %v", err)
+	}
+	if err := kv.Set(testObj.ctx, "key", "value"); err != nil {
+		t.Fatalf("Error setting key: %v", err)
+	}
+
+	objStore, err := p.ObjectStore()
+	if err != nil || objStore == nil {
+		t.Fatalf("Error getting object store provider: %v", err)
+	}
+	if _, err := testObj.js.ObjectStore(defaultObjectStore); err != nil {
+		t.Fatalf("Object store was not created lazily: %v", err)
+	}
 
-	os.Exit(m.Run())
+	stream, err := p.Stream()
+	if err != nil || stream == nil {
+		t.Fatalf("Error getting stream provider: %v", err)
+	}
+
+	cfg, err := p.Config()
+	if err != nil || cfg == nil {
+		t.Fatalf("Error getting config provider: %v", err)
+	}
+	if err := cfg.SetConfigValue("app.name", "provider"); err != nil {
+		t.Fatalf("Error setting config value: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Error closing provider: %v", err)
+	}
+	if _, err := cfg.GetConfigValue("app.name"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed after close, got %v", err)
+	}
+}
+
+func TestNATSProviderDrain(t *testing.T) {
+	p, err := NewNATSProvider(testObj.url, WithKVBucket("drain_kv"), WithShutdownTimeout(2*time.Second))
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+
+	kv, err := p.KeyValue()
+	if err != nil {
+		t.Fatalf("Error getting key-value provider: %v", err)
+	}
+	if err := kv.Watch(testObj.ctx, "watched", func(string, string) {}); err != nil {
+		t.Fatalf("Error watching key: %v", err)
+	}
+
+	received := make(chan struct{}, 1)
+	if _, err := p.Core().Subscribe(testObj.ctx, "drain.test", func(*Message) {
+		time.Sleep(100 * time.Millisecond)
+		received <- struct{}{}
+	}); err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	if err := p.Core().Publish(testObj.ctx, "drain.test", []byte("in-flight"), nil); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+
+	if err := p.Drain(testObj.ctx); err != nil {
+		t.Fatalf("Error draining provider: %v", err)
+	}
+
+	select {
+	case <-received:
+	default:
+		t.Fatal("In-flight message was dropped during drain")
+	}
+	if err := kv.Watch(testObj.ctx, "other", func(string, string) {}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Expected ErrClosed after drain, got %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Error closing drained provider: %v", err)
+	}
+}
+
+func TestContextDeadlineMidCall(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatalf("Error creating nats server: %v", err)
+	}
+	go ns.Start()
+	if !ns.ReadyForConnections(4 * time.Second) {
+		t.Fatal("Error starting nats server")
+	}
+	defer ns.Shutdown()
+
+	nc, err := nats.Connect(ns.ClientURL(), nats.MaxReconnects(-1))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+	js, err := nc.JetStream()
+	if err != nil {
+		t.Fatalf("Error creating jetstream context: %v", err)
+	}
+
+	kv, err := NewKeyValueProvider(js, "deadline_kv")
+	if err != nil {
+		t.Fatalf("Error creating key-value provider: %v", err)
+	}
+	objStore, err := NewObjectStoreProvider(js, "deadline_objects")
+	if err != nil {
+		t.Fatalf("Error creating object store provider: %v", err)
+	}
+
+	// Without a server every call blocks until the client's own timeout,
+	// so only the deadline can end it.
+	ns.Shutdown()
+
+	calls := map[string]func(context.Context) error{
+		"Get": func(ctx context.Context) error {
+			_, err := kv.Get(ctx, "key")
+			return err
+		},
+		"Set": func(ctx context.Context) error {
+			return kv.Set(ctx, "key", "value")
+		},
+		"DeleteObject": func(ctx context.Context) error {
+			return objStore.DeleteObject(ctx, "object")
+		},
+		"Status": func(ctx context.Context) error {
+			_, err := objStore.Status(ctx)
+			return err
+		},
+	}
+	for name, call := range calls {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		err := call(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("%s: expected context.DeadlineExceeded, got %v", name, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("%s: returned after %v, past the deadline", name, elapsed)
+		}
+	}
 }
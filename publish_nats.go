@@ -0,0 +1,211 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const defaultAckTimeout = 5 * time.Second
+
+// PubAck is the stream's acknowledgement of a published message.
+type PubAck struct {
+	Stream    string
+	Sequence  uint64
+	Duplicate bool
+	Domain    string
+}
+
+// ErrUnexpectedStream is returned together with the PubAck when a message
+// was stored in a stream other than the one named. The message WAS stored, so
+// retrying the publish stores it again. Use WithExpectStream to have the
+// server refuse the message instead.
+var ErrUnexpectedStream = errors.New("natsprovider: message stored in unexpected stream")
+
+// newPubAck converts ack. When a non-empty stream did not store the message,
+// the ack is returned with an error wrapping ErrUnexpectedStream.
+func newPubAck(stream string, ack *nats.PubAck) (*PubAck, error) {
+	pa := &PubAck{
+		Stream:    ack.Stream,
+		Sequence:  ack.Sequence,
+		Duplicate: ack.Duplicate,
+		Domain:    ack.Domain,
+	}
+	if stream != "" && ack.Stream != stream {
+		return pa, fmt.Errorf("%w %q, expected %q", ErrUnexpectedStream, ack.Stream, stream)
+	}
+	return pa, nil
+}
+
+// PublishOption sets expectations checked by the stream before it stores a
+// published message.
+type PublishOption func(*publishOptions)
+
+type publishOptions struct {
+	natsOpts []nats.PubOpt
+}
+
+func publishOpts(opts ...PublishOption) []nats.PubOpt {
+	o := &publishOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o.natsOpts
+}
+
+// WithExpectStream has the server reject the publish unless stream would
+// store it. The expectation travels as a header that is stored with the
+// message, and mirrors of the stream refuse such messages.
+func WithExpectStream(stream string) PublishOption {
+	return func(o *publishOptions) {
+		o.natsOpts = append(o.natsOpts, nats.ExpectStream(stream))
+	}
+}
+
+// WithMsgID sets the Nats-Msg-Id header used by the stream to drop
+// duplicates within its duplicate window.
+func WithMsgID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.natsOpts = append(o.natsOpts, nats.MsgId(id))
+	}
+}
+
+// WithExpectLastSequence rejects the publish unless seq is the stream's last
+// sequence.
+func WithExpectLastSequence(seq uint64) PublishOption {
+	return func(o *publishOptions) {
+		o.natsOpts = append(o.natsOpts, nats.ExpectLastSequence(seq))
+	}
+}
+
+// WithExpectLastSequencePerSubject rejects the publish unless seq is the last
+// sequence stored for the message subject.
+func WithExpectLastSequencePerSubject(seq uint64) PublishOption {
+	return func(o *publishOptions) {
+		o.natsOpts = append(o.natsOpts, nats.ExpectLastSequencePerSubject(seq))
+	}
+}
+
+// WithExpectLastMsgID rejects the publish unless id is the Nats-Msg-Id of the
+// stream's last message.
+func WithExpectLastMsgID(id string) PublishOption {
+	return func(o *publishOptions) {
+		o.natsOpts = append(o.natsOpts, nats.ExpectLastMsgId(id))
+	}
+}
+
+// AsyncPublisher pipelines publishes to a stream, keeping at most a fixed
+// number of messages waiting for their acknowledgement.
+type AsyncPublisher interface {
+	// Publish sends the message without waiting for its ack. It blocks while
+	// the in-flight window is full or until ctx is done. The future fails
+	// when ctx ends or the ack timeout passes before the ack arrives, and its
+	// slot is freed as soon as it resolves.
+	Publish(ctx context.Context, subject string, msg []byte, headers map[string]string, opts ...PublishOption) (*PubAckFuture, error)
+	// Wait blocks until every future returned so far has resolved.
+	Wait(ctx context.Context) error
+}
+
+// AsyncPublisherOption configures an AsyncPublisher.
+type AsyncPublisherOption func(*asyncPublisher)
+
+// WithAckTimeout sets how long a future waits for its ack before failing
+// with nats.ErrTimeout and freeing its in-flight slot. The message may still
+// be stored.
+func WithAckTimeout(timeout time.Duration) AsyncPublisherOption {
+	return func(p *asyncPublisher) {
+		if timeout > 0 {
+			p.timeout = timeout
+		}
+	}
+}
+
+// PubAckFuture resolves once the stream acknowledges a message published
+// through an AsyncPublisher.
+type PubAckFuture struct {
+	done chan struct{}
+	once sync.Once
+	ack  *PubAck
+	err  error
+}
+
+// Done is closed once the result is available.
+func (f *PubAckFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Result waits for the acknowledgement or until ctx is done.
+func (f *PubAckFuture) Result(ctx context.Context) (*PubAck, error) {
+	select {
+	case <-f.done:
+		return f.ack, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolve sets the result unless one was already set.
+func (f *PubAckFuture) resolve(ack *PubAck, err error) {
+	f.once.Do(func() {
+		f.ack, f.err = ack, err
+		close(f.done)
+	})
+}
+
+// asyncPublisher settles every publish on its own goroutine, so a slow or
+// lost ack only holds its own slot, and never past the ack timeout.
+type asyncPublisher struct {
+	js      nats.JetStreamContext
+	stream  string
+	timeout time.Duration
+	slots   chan struct{}
+	wg      sync.WaitGroup
+}
+
+func (p *asyncPublisher) Publish(ctx context.Context, subject string, msg []byte, headers map[string]string, opts ...PublishOption) (*PubAckFuture, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	paf, err := p.js.PublishMsgAsync(newMsg(ctx, subject, msg, headers), publishOpts(opts...)...)
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+
+	f := &PubAckFuture{done: make(chan struct{})}
+	p.wg.Add(1)
+	go p.settle(ctx, paf, f)
+	return f, nil
+}
+
+// settle resolves f with the first of the ack, the publish error, the ack
+// timeout or the end of ctx, and frees the slot.
+func (p *asyncPublisher) settle(ctx context.Context, paf nats.PubAckFuture, f *PubAckFuture) {
+	defer p.wg.Done()
+	defer func() { <-p.slots }()
+
+	timer := time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case ack := <-paf.Ok():
+		f.resolve(newPubAck(p.stream, ack))
+	case err := <-paf.Err():
+		f.resolve(nil, err)
+	case <-timer.C:
+		f.resolve(nil, nats.ErrTimeout)
+	case <-ctx.Done():
+		f.resolve(nil, ctx.Err())
+	}
+}
+
+func (p *asyncPublisher) Wait(ctx context.Context) error {
+	return waitGroupContext(ctx, p.wg.Wait)
+}
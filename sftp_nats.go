@@ -0,0 +1,399 @@
+package natsprovider
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// SFTPUser is an account of an SFTPServer.
+type SFTPUser struct {
+	Name string
+	// Password and PublicKeys are the accepted credentials. Leave Password
+	// empty to allow key authentication only.
+	Password   string
+	PublicKeys []ssh.PublicKey
+	// Root is the directory the user sees as "/". It is created on login
+	// unless the user is read-only.
+	Root     string
+	ReadOnly bool
+}
+
+// SFTPOption configures an SFTPServer built by NewSFTPServer.
+type SFTPOption func(*sftpOptions)
+
+type sftpOptions struct {
+	hostKeys []ssh.Signer
+	users    map[string]SFTPUser
+	readOnly bool
+}
+
+// WithSFTPHostKey adds a key the server identifies itself with. At least one
+// is required.
+func WithSFTPHostKey(key ssh.Signer) SFTPOption {
+	return func(o *sftpOptions) {
+		o.hostKeys = append(o.hostKeys, key)
+	}
+}
+
+// WithSFTPUsers adds accounts allowed to log in.
+func WithSFTPUsers(users ...SFTPUser) SFTPOption {
+	return func(o *sftpOptions) {
+		for _, user := range users {
+			o.users[user.Name] = user
+		}
+	}
+}
+
+// WithSFTPReadOnly makes the server read-only for every user.
+func WithSFTPReadOnly() SFTPOption {
+	return func(o *sftpOptions) {
+		o.readOnly = true
+	}
+}
+
+// ErrServerClosed is returned by SFTPServer.Serve after Close. The file
+// system behind the server stays usable.
+var ErrServerClosed = errors.New("natsprovider: sftp server closed")
+
+// SFTPServer serves a FilesystemProvider over SFTP, so uploads land directly
+// in the object store bucket behind it.
+type SFTPServer struct {
+	fsys      FilesystemProvider
+	opts      sftpOptions
+	config    *ssh.ServerConfig
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+	closed    bool
+}
+
+func NewSFTPServer(fsys FilesystemProvider, opts ...SFTPOption) (*SFTPServer, error) {
+	o := sftpOptions{users: make(map[string]SFTPUser)}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(o.hostKeys) == 0 {
+		return nil, errors.New("sftp server needs a host key")
+	}
+	if len(o.users) == 0 {
+		return nil, errors.New("sftp server needs at least one user")
+	}
+
+	s := &SFTPServer{
+		fsys:      fsys,
+		opts:      o,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	s.config = &ssh.ServerConfig{
+		PasswordCallback:  s.checkPassword,
+		PublicKeyCallback: s.checkPublicKey,
+	}
+	for _, key := range o.hostKeys {
+		s.config.AddHostKey(key)
+	}
+	return s, nil
+}
+
+func (s *SFTPServer) checkPassword(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	user, ok := s.opts.users[meta.User()]
+	if ok && user.Password != "" && subtle.ConstantTimeCompare([]byte(user.Password), password) == 1 {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("password rejected for %q", meta.User())
+}
+
+func (s *SFTPServer) checkPublicKey(meta ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if user, ok := s.opts.users[meta.User()]; ok {
+		for _, allowed := range user.PublicKeys {
+			if bytes.Equal(allowed.Marshal(), key.Marshal()) {
+				return nil, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("public key rejected for %q", meta.User())
+}
+
+// Serve accepts connections on l until Close is called, then returns
+// ErrServerClosed.
+func (s *SFTPServer) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.lock.Unlock()
+
+	defer func() {
+		s.lock.Lock()
+		delete(s.listeners, l)
+		s.lock.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.lock.Unlock()
+
+		go s.handleConn(conn)
+	}
+}
+
+// Close stops every listener and connection and waits for open requests to
+// finish, which writes back files still open for writing.
+func (s *SFTPServer) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	var errs []error
+	for l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	for conn := range s.conns {
+		if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *SFTPServer) handleConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.lock.Lock()
+		delete(s.conns, conn)
+		s.lock.Unlock()
+		conn.Close()
+	}()
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	user := s.opts.users[sconn.User()]
+	handler := &sftpHandler{fsys: s.fsys, root: cleanPath(user.Root), readOnly: s.opts.readOnly || user.ReadOnly}
+	if handler.root != "" && !handler.readOnly {
+		if err := s.fsys.MkdirAll(handler.root, defaultDirMode); err != nil {
+			return
+		}
+	}
+	handlers := sftp.Handlers{FileGet: handler, FilePut: handler, FileCmd: handler, FileList: handler}
+
+	var sessions sync.WaitGroup
+	defer sessions.Wait()
+	for newChan := range chans {
+		if newChan.ChannelType() != "session" {
+			_ = newChan.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := newChan.Accept()
+		if err != nil {
+			continue
+		}
+		sessions.Add(1)
+		go func() {
+			defer sessions.Done()
+			serveSFTPSession(ch, requests, handlers)
+		}()
+	}
+}
+
+// serveSFTPSession runs the sftp subsystem once the client asks for it and
+// refuses every other request, such as shells or commands.
+func serveSFTPSession(ch ssh.Channel, requests <-chan *ssh.Request, handlers sftp.Handlers) {
+	defer ch.Close()
+
+	for req := range requests {
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		_ = req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+
+		go ssh.DiscardRequests(requests)
+		server := sftp.NewRequestServer(ch, handlers)
+		_ = server.Serve()
+		_ = server.Close()
+		return
+	}
+}
+
+// sftpHandler maps sftp requests onto the file system below root.
+type sftpHandler struct {
+	fsys     FilesystemProvider
+	root     string
+	readOnly bool
+}
+
+func (h *sftpHandler) path(p string) string {
+	return path.Join(h.root, path.Clean("/"+p))
+}
+
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	return h.fsys.Open(h.path(r.Filepath))
+}
+
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	return h.openFile(r)
+}
+
+func (h *sftpHandler) OpenFile(r *sftp.Request) (sftp.WriterAtReaderAt, error) {
+	return h.openFile(r)
+}
+
+// openFile ignores the append flag: sftp writes always carry an offset.
+func (h *sftpHandler) openFile(r *sftp.Request) (File, error) {
+	pflags := r.Pflags()
+	if h.readOnly && (pflags.Write || pflags.Creat || pflags.Trunc) {
+		return nil, sftp.ErrSSHFxPermissionDenied
+	}
+
+	flag := os.O_RDONLY
+	switch {
+	case pflags.Read && pflags.Write:
+		flag = os.O_RDWR
+	case pflags.Write:
+		flag = os.O_WRONLY
+	}
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+	return h.fsys.OpenFile(h.path(r.Filepath), flag, defaultFileMode)
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	if h.readOnly {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+
+	p := h.path(r.Filepath)
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(r, p)
+	case "Rename":
+		// SFTP v3 rename must not replace an existing target.
+		target := h.path(r.Target)
+		if _, err := h.fsys.Stat(target); err == nil {
+			return sftp.ErrSSHFxFailure
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return h.fsys.Rename(p, target)
+	case "Mkdir":
+		return h.fsys.Mkdir(p, defaultDirMode)
+	case "Rmdir", "Remove":
+		return h.fsys.Remove(p)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename serves posix-rename@openssh.com, which unlike Rename replaces
+// an existing target.
+func (h *sftpHandler) PosixRename(r *sftp.Request) error {
+	if h.readOnly {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return h.fsys.Rename(h.path(r.Filepath), h.path(r.Target))
+}
+
+// setstat applies size and times. Permissions and ownership are not tracked
+// per user and are ignored.
+func (h *sftpHandler) setstat(r *sftp.Request, p string) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.Size {
+		f, err := h.fsys.OpenFile(p, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		if err := f.Truncate(int64(attrs.Size)); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		return h.fsys.Chtimes(p, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0))
+	}
+	return nil
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	p := h.path(r.Filepath)
+	switch r.Method {
+	case "List":
+		dir, err := h.fsys.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer dir.Close()
+		infos, err := dir.Readdir(-1)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(infos), nil
+	case "Stat":
+		info, err := h.fsys.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt{info}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+type listerAt []fs.FileInfo
+
+func (l listerAt) ListAt(ls []fs.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(ls, l[offset:])
+	if n < len(ls) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,177 @@
+package natsprovider
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func dialSFTP(t *testing.T, addr, user string, auth ssh.AuthMethod) (*sftp.Client, error) {
+	t.Helper()
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         2 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	t.Cleanup(func() {
+		client.Close()
+		conn.Close()
+	})
+	return client, nil
+}
+
+func TestSFTPServer(t *testing.T) {
+	ctx := context.Background()
+
+	objects, err := NewObjectStoreProvider(testObj.js, "sftp_objects")
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("sftp_objects")
+	dirs, err := NewKeyValueProvider(testObj.js, "sftp_dirs")
+	if err != nil {
+		t.Fatalf("Error creating key-value store: %v", err)
+	}
+	defer testObj.js.DeleteKeyValue("sftp_dirs")
+
+	auditorKey := newSigner(t)
+	srv, err := NewSFTPServer(NewFilesystemProvider(ctx, objects, dirs),
+		WithSFTPHostKey(newSigner(t)),
+		WithSFTPUsers(
+			SFTPUser{Name: "acme", Password: "s3cr3t", Root: "/partners/acme"},
+			SFTPUser{Name: "auditor", PublicKeys: []ssh.PublicKey{auditorKey.PublicKey()}, Root: "/partners", ReadOnly: true},
+		),
+	)
+	if err != nil {
+		t.Fatalf("Error creating sftp server: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	if _, err := dialSFTP(t, l.Addr().String(), "acme", ssh.Password("wrong")); err == nil {
+		t.Fatal("Expected authentication error with wrong password")
+	}
+
+	acme, err := dialSFTP(t, l.Addr().String(), "acme", ssh.Password("s3cr3t"))
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	if err := acme.Mkdir("/in"); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	f, err := acme.Create("/in/orders.csv")
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	if _, err := f.Write([]byte("id,qty\n1,5\n")); err != nil {
+		t.Fatalf("Error writing: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+
+	data, err := objects.GetObject(ctx, "partners/acme/in/orders.csv")
+	if err != nil || string(data) != "id,qty\n1,5\n" {
+		t.Fatalf("Expected upload in the bucket, got %q, %v", data, err)
+	}
+
+	// Plain SFTP rename keeps an existing target; the posix extension
+	// replaces it.
+	draft, err := acme.Create("/in/draft.csv")
+	if err != nil {
+		t.Fatalf("Error creating file: %v", err)
+	}
+	if err := draft.Close(); err != nil {
+		t.Fatalf("Error closing: %v", err)
+	}
+	var status *sftp.StatusError
+	if err := acme.Rename("/in/orders.csv", "/in/draft.csv"); !errors.As(err, &status) || status.FxCode() != sftp.ErrSSHFxFailure {
+		t.Fatalf("Expected SSH_FX_FAILURE renaming onto an existing file, got %v", err)
+	}
+	if err := acme.PosixRename("/in/orders.csv", "/in/draft.csv"); err != nil {
+		t.Fatalf("Error renaming with posix-rename: %v", err)
+	}
+	if err := acme.Rename("/in/draft.csv", "/in/orders-1.csv"); err != nil {
+		t.Fatalf("Error renaming: %v", err)
+	}
+	if err := acme.Truncate("/in/orders-1.csv", 6); err != nil {
+		t.Fatalf("Error truncating: %v", err)
+	}
+	mtime := time.Unix(1700000000, 0)
+	if err := acme.Chtimes("/in/orders-1.csv", mtime, mtime); err != nil {
+		t.Fatalf("Error setting times: %v", err)
+	}
+	infos, err := acme.ReadDir("/in")
+	if err != nil || len(infos) != 1 || infos[0].Name() != "orders-1.csv" || infos[0].Size() != 6 {
+		t.Fatalf("Unexpected listing %v, %v", infos, err)
+	}
+	if _, err := acme.Stat("/../../etc/passwd"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected paths to stay below the user root, got %v", err)
+	}
+
+	auditor, err := dialSFTP(t, l.Addr().String(), "auditor", ssh.PublicKeys(auditorKey))
+	if err != nil {
+		t.Fatalf("Error connecting with public key: %v", err)
+	}
+	r, err := auditor.Open("/acme/in/orders-1.csv")
+	if err != nil {
+		t.Fatalf("Error opening: %v", err)
+	}
+	data, err = io.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "id,qty" {
+		t.Fatalf("Unexpected content %q, %v", data, err)
+	}
+	if info, err := auditor.Stat("/acme/in/orders-1.csv"); err != nil || !info.ModTime().Equal(mtime) {
+		t.Fatalf("Unexpected file info %v, %v", info, err)
+	}
+	if _, err := auditor.Create("/acme/in/evil.csv"); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("Expected permission error for read-only user, got %v", err)
+	}
+	if err := auditor.Remove("/acme/in/orders-1.csv"); !errors.Is(err, os.ErrPermission) {
+		t.Fatalf("Expected permission error for read-only user, got %v", err)
+	}
+
+	if err := srv.Close(); err != nil {
+		t.Fatalf("Error closing server: %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Fatalf("Expected ErrServerClosed from Serve, got %v", err)
+	}
+}
@@ -1,80 +1,343 @@
 package natsprovider
 
 import (
-	"github.com/inovacc/nats-provider/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/inovacc/nats-provider/utils"
 	"github.com/nats-io/nats.go"
 )
 
 type streamProvider struct {
-	js nats.JetStreamContext
+	js        nats.JetStreamContext
+	consumers map[*streamConsumer]struct{}
+	wg        sync.WaitGroup
+	lock      sync.Mutex
+	closed    bool
 }
 
 func NewStreamProvider(js nats.JetStreamContext) StreamProvider {
-	return &streamProvider{js: js}
+	return &streamProvider{
+		js:        js,
+		consumers: make(map[*streamConsumer]struct{}),
+	}
 }
 
-func (s *streamProvider) CreateStream(name string, subjects []string) error {
-	_, err := s.js.AddStream(&nats.StreamConfig{
-		Name:     name,
-		Subjects: subjects,
-		Storage:  nats.FileStorage,
-	})
+func (s *streamProvider) CreateStream(ctx context.Context, spec StreamSpec) error {
+	cfg, err := spec.config()
+	if err != nil {
+		return err
+	}
+	_, err = s.js.AddStream(cfg, nats.Context(ctx))
 	return err
 }
 
-func (s *streamProvider) DeleteStream(name string) error {
-	return s.js.DeleteStream(name)
+func (s *streamProvider) CreateOrUpdateStream(ctx context.Context, spec StreamSpec) (*StreamUpdate, error) {
+	info, err := s.js.StreamInfo(spec.Name, nats.Context(ctx))
+	if errors.Is(err, nats.ErrStreamNotFound) {
+		if err := s.CreateStream(ctx, spec); err != nil {
+			return nil, err
+		}
+		return &StreamUpdate{Created: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	changes := diffStreamSpecs(streamSpecFromConfig(info.Config), spec)
+	if len(changes) == 0 {
+		return &StreamUpdate{}, nil
+	}
+
+	cfg, err := spec.config()
+	if err != nil {
+		return nil, err
+	}
+	keepUnmodeled(cfg, info.Config)
+	if _, err := s.js.UpdateStream(cfg, nats.Context(ctx)); err != nil {
+		return nil, err
+	}
+	return &StreamUpdate{Changes: changes}, nil
 }
 
-func (s *streamProvider) PublishToStream(stream string, subject string, msg []byte, headers map[string]string) error {
-	m := &nats.Msg{Subject: subject, Data: msg, Header: nats.Header{}}
-	for k, v := range headers {
-		m.Header.Set(k, v)
+func (s *streamProvider) DeleteStream(ctx context.Context, name string) error {
+	return s.js.DeleteStream(name, nats.Context(ctx))
+}
+
+func (s *streamProvider) PublishToStream(ctx context.Context, stream string, subject string, msg []byte, headers map[string]string, opts ...PublishOption) (*PubAck, error) {
+	ack, err := s.js.PublishMsg(newMsg(ctx, subject, msg, headers), append(publishOpts(opts...), nats.Context(ctx))...)
+	if err != nil {
+		return nil, err
 	}
-	_, err := s.js.PublishMsg(m)
-	return err
+	return newPubAck(stream, ack)
+}
+
+func (s *streamProvider) AsyncPublisher(stream string, maxInFlight int, opts ...AsyncPublisherOption) AsyncPublisher {
+	p := &asyncPublisher{
+		js:      s.js,
+		stream:  stream,
+		timeout: defaultAckTimeout,
+		slots:   make(chan struct{}, max(maxInFlight, 1)),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
 }
 
-func (s *streamProvider) SubscribeToStream(stream string, durableName string, handler MsgHandler) (Unsubscriber, error) {
-	sub, err := s.js.PullSubscribe("$JS."+stream+".*", durableName)
+func (s *streamProvider) SubscribeToStream(ctx context.Context, stream, durable string, handler StreamHandler, opts ...ConsumeOption) (Unsubscriber, error) {
+	s.lock.Lock()
+	closed := s.closed
+	s.lock.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	// Create the durable up front and bind to it, so unsubscribing keeps the
+	// consumer and its progress instead of deleting it.
+	_, err := s.ConsumerInfo(ctx, stream, durable)
+	if errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = s.AddConsumer(ctx, stream, ConsumerSpec{Durable: durable})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// No nats.Context here: the client would unsubscribe on its own when ctx
+	// ends, racing the cleanup in run.
+	sub, err := s.js.PullSubscribe("", durable, nats.Bind(stream, durable))
 	if err != nil {
 		return nil, err
 	}
 
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// The provider may have been closed while the subscription was set up.
+	if s.closed {
+		_ = sub.Unsubscribe()
+		return nil, ErrClosed
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	c := &streamConsumer{
+		provider: s,
+		sub:      sub,
+		handler:  handler,
+		opts:     newConsumeOptions(opts...),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	s.consumers[c] = struct{}{}
+	s.wg.Add(1)
+
 	go func() {
-		for {
-			msgs, err := sub.Fetch(1)
-			if err != nil {
-				continue
-			}
-			for _, m := range msgs {
-				handler(&Message{
-					Subject: m.Subject,
-					Reply:   m.Reply,
-					Data:    m.Data,
-					Headers: utils.HeaderMap(m.Header),
-				})
-				m.Ack()
-			}
-		}
+		defer s.wg.Done()
+		c.run(runCtx)
 	}()
 
-	return sub, nil
+	return c, nil
 }
 
-func (s *streamProvider) CreateMirrorStream(name, sourceStream string) error {
-	_, err := s.js.AddStream(&nats.StreamConfig{
-		Name:   name,
-		Mirror: &nats.StreamSource{Name: sourceStream},
-	})
+func (s *streamProvider) stopConsumers() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.closed = true
+	for c := range s.consumers {
+		c.cancel()
+	}
+}
+
+func (s *streamProvider) waitConsumers(ctx context.Context) error {
+	return waitGroupContext(ctx, s.wg.Wait)
+}
+
+func (s *streamProvider) CreateMirrorStream(ctx context.Context, spec StreamSpec, source StreamSource) error {
+	if len(spec.Subjects) > 0 {
+		return fmt.Errorf("mirror stream %q cannot listen on subjects", spec.Name)
+	}
+	cfg, err := spec.config()
+	if err != nil {
+		return err
+	}
+	cfg.Mirror = source.config()
+	_, err = s.js.AddStream(cfg, nats.Context(ctx))
 	return err
 }
 
-func (s *streamProvider) CreateSourceStream(name string, sourceSubject string) error {
-	_, err := s.js.AddStream(&nats.StreamConfig{
-		Name:     name,
-		Subjects: []string{sourceSubject},
-	})
+func (s *streamProvider) CreateSourceStream(ctx context.Context, spec StreamSpec, sources ...StreamSource) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("source stream %q needs at least one source", spec.Name)
+	}
+	cfg, err := spec.config()
+	if err != nil {
+		return err
+	}
+	for _, source := range sources {
+		cfg.Sources = append(cfg.Sources, source.config())
+	}
+	_, err = s.js.AddStream(cfg, nats.Context(ctx))
+	if errors.Is(err, nats.ErrStreamSourceMultipleSubjectTransformsNotSupported) {
+		// The client matches transforms against the source status list by
+		// index, and the server does not return it in config order. Check
+		// the stored config instead.
+		return s.checkSourceTransforms(ctx, cfg, err)
+	}
 	return err
 }
+
+func (s *streamProvider) checkSourceTransforms(ctx context.Context, cfg *nats.StreamConfig, addErr error) error {
+	info, err := s.js.StreamInfo(cfg.Name, nats.Context(ctx))
+	if err != nil {
+		return errors.Join(addErr, err)
+	}
+
+	// One stream may be sourced several times with different subjects, so
+	// compare the source lists as multisets.
+	if len(info.Config.Sources) != len(cfg.Sources) {
+		return addErr
+	}
+	stored := make(map[string]int, len(info.Config.Sources))
+	for _, source := range info.Config.Sources {
+		stored[sourceKey(source)]++
+	}
+	for _, source := range cfg.Sources {
+		key := sourceKey(source)
+		if stored[key] == 0 {
+			return addErr
+		}
+		stored[key]--
+	}
+	return nil
+}
+
+// sourceKey identifies a source by its stream and the subjects it copies.
+func sourceKey(source *nats.StreamSource) string {
+	parts := []string{source.Name, source.FilterSubject}
+	for _, transform := range source.SubjectTransforms {
+		parts = append(parts, transform.Source, transform.Destination)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// StoredMessage is a message read directly from a stream.
+type StoredMessage struct {
+	Message
+	Sequence  uint64
+	Timestamp time.Time
+}
+
+func newStoredMessage(m *nats.RawStreamMsg) *StoredMessage {
+	return &StoredMessage{
+		Message: Message{
+			Subject: m.Subject,
+			Data:    m.Data,
+			Headers: utils.HeaderMap(m.Header),
+		},
+		Sequence:  m.Sequence,
+		Timestamp: m.Time,
+	}
+}
+
+func (s *streamProvider) GetMessage(ctx context.Context, stream string, seq uint64) (*StoredMessage, error) {
+	m, err := s.js.GetMsg(stream, seq, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newStoredMessage(m), nil
+}
+
+func (s *streamProvider) GetLastMessageForSubject(ctx context.Context, stream, subject string) (*StoredMessage, error) {
+	m, err := s.js.GetLastMsg(stream, subject, nats.Context(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return newStoredMessage(m), nil
+}
+
+func (s *streamProvider) DeleteMessage(ctx context.Context, stream string, seq uint64, erase bool) error {
+	if erase {
+		return s.js.SecureDeleteMsg(stream, seq, nats.Context(ctx))
+	}
+	return s.js.DeleteMsg(stream, seq, nats.Context(ctx))
+}
+
+// PurgeOption narrows what PurgeStream removes.
+type PurgeOption func(*nats.StreamPurgeRequest)
+
+// WithPurgeSubject only purges messages matching subject.
+func WithPurgeSubject(subject string) PurgeOption {
+	return func(r *nats.StreamPurgeRequest) {
+		r.Subject = subject
+	}
+}
+
+// WithPurgeKeep keeps the newest n messages.
+func WithPurgeKeep(n uint64) PurgeOption {
+	return func(r *nats.StreamPurgeRequest) {
+		r.Keep = n
+	}
+}
+
+// WithPurgeUpToSequence purges messages below seq, keeping seq itself.
+func WithPurgeUpToSequence(seq uint64) PurgeOption {
+	return func(r *nats.StreamPurgeRequest) {
+		r.Sequence = seq
+	}
+}
+
+func (s *streamProvider) PurgeStream(ctx context.Context, stream string, opts ...PurgeOption) error {
+	req := &nats.StreamPurgeRequest{}
+	for _, opt := range opts {
+		opt(req)
+	}
+	return s.js.PurgeStream(stream, req, nats.Context(ctx))
+}
+
+// StreamInfo reports the configuration and state of a stream.
+type StreamInfo struct {
+	Spec        StreamSpec
+	Created     time.Time
+	Msgs        uint64
+	Bytes       uint64
+	FirstSeq    uint64
+	FirstTime   time.Time
+	LastSeq     uint64
+	LastTime    time.Time
+	Consumers   int
+	NumDeleted  int
+	NumSubjects uint64
+	// Subjects holds message counts per subject matching the filter given
+	// to StreamInfo.
+	Subjects map[string]uint64
+}
+
+func (s *streamProvider) StreamInfo(ctx context.Context, stream, subjectsFilter string) (*StreamInfo, error) {
+	opts := []nats.JSOpt{nats.Context(ctx)}
+	if subjectsFilter != "" {
+		opts = append(opts, &nats.StreamInfoRequest{SubjectsFilter: subjectsFilter})
+	}
+	info, err := s.js.StreamInfo(stream, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamInfo{
+		Spec:        streamSpecFromConfig(info.Config),
+		Created:     info.Created,
+		Msgs:        info.State.Msgs,
+		Bytes:       info.State.Bytes,
+		FirstSeq:    info.State.FirstSeq,
+		FirstTime:   info.State.FirstTime,
+		LastSeq:     info.State.LastSeq,
+		LastTime:    info.State.LastTime,
+		Consumers:   info.State.Consumers,
+		NumDeleted:  info.State.NumDeleted,
+		NumSubjects: info.State.NumSubjects,
+		Subjects:    info.State.Subjects,
+	}, nil
+}
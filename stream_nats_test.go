@@ -0,0 +1,531 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+func TestSubscribeToStream(t *testing.T) {
+	s := NewStreamProvider(testObj.js)
+	if err := s.CreateStream(testObj.ctx, StreamSpec{Name: "ORDERS", Subjects: []string{"orders.>"}}); err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "ORDERS")
+
+	for _, subject := range []string{"orders.ok", "orders.retry", "orders.poison"} {
+		if _, err := s.PublishToStream(testObj.ctx, "ORDERS", subject, []byte(subject), nil); err != nil {
+			t.Fatalf("Error publishing: %v", err)
+		}
+	}
+
+	var acked, retried, poisoned atomic.Int32
+	done := make(chan struct{})
+	sub, err := s.SubscribeToStream(testObj.ctx, "ORDERS", "worker", func(ctx context.Context, msg *StreamMessage) error {
+		switch msg.Subject {
+		case "orders.retry":
+			if msg.NumDelivered == 1 {
+				retried.Add(1)
+				return NakWithDelay(50*time.Millisecond, errors.New("busy"))
+			}
+		case "orders.poison":
+			poisoned.Add(1)
+			return Terminate(errors.New("bad payload"))
+		}
+		if acked.Add(1) == 2 {
+			close(done)
+		}
+		return nil
+	}, WithFetchBatch(10), WithFetchMaxWait(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for messages")
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Error unsubscribing: %v", err)
+	}
+
+	if retried.Load() != 1 || poisoned.Load() != 1 {
+		t.Fatalf("Expected one retry and one termination, got %d and %d", retried.Load(), poisoned.Load())
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		info, err := testObj.js.ConsumerInfo("ORDERS", "worker")
+		if err != nil {
+			t.Fatalf("Durable was removed on unsubscribe: %v", err)
+		}
+		if info.NumAckPending == 0 && info.NumPending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected every message settled, got %d ack pending and %d pending", info.NumAckPending, info.NumPending)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestSubscribeToStreamCancel(t *testing.T) {
+	s := NewStreamProvider(testObj.js).(*streamProvider)
+	if err := s.CreateStream(testObj.ctx, StreamSpec{Name: "CANCEL", Subjects: []string{"cancel.>"}}); err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "CANCEL")
+
+	ctx, cancel := context.WithCancel(testObj.ctx)
+	sub, err := s.SubscribeToStream(ctx, "CANCEL", "worker", func(ctx context.Context, msg *StreamMessage) error {
+		return nil
+	}, WithFetchMaxWait(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	c := sub.(*streamConsumer)
+
+	cancel()
+	select {
+	case <-c.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the fetch loop to stop")
+	}
+	s.lock.Lock()
+	_, tracked := s.consumers[c]
+	s.lock.Unlock()
+	if tracked || c.sub.IsValid() {
+		t.Fatal("Expected the subscription to be removed when the context was cancelled")
+	}
+
+	for range 2 {
+		if err := sub.Unsubscribe(); err != nil {
+			t.Fatalf("Error unsubscribing: %v", err)
+		}
+	}
+}
+
+func TestUnsubscribeFromHandler(t *testing.T) {
+	s := NewStreamProvider(testObj.js)
+	if err := s.CreateStream(testObj.ctx, StreamSpec{Name: "REENTRANT", Subjects: []string{"reentrant.>"}}); err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "REENTRANT")
+
+	if _, err := s.PublishToStream(testObj.ctx, "REENTRANT", "reentrant.msg", []byte("stop"), nil); err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+
+	var sub Unsubscriber
+	ready := make(chan struct{})
+	returned := make(chan error, 1)
+	sub, err := s.SubscribeToStream(testObj.ctx, "REENTRANT", "worker", func(ctx context.Context, msg *StreamMessage) error {
+		<-ready
+		returned <- sub.Unsubscribe()
+		return nil
+	}, WithFetchMaxWait(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	close(ready)
+
+	select {
+	case err := <-returned:
+		if err != nil {
+			t.Fatalf("Error unsubscribing from the handler: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Unsubscribe from the handler deadlocked")
+	}
+	select {
+	case <-sub.(*streamConsumer).done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the fetch loop to stop")
+	}
+	if err := sub.Unsubscribe(); err != nil {
+		t.Fatalf("Error unsubscribing again: %v", err)
+	}
+}
+
+func TestConsumerManagement(t *testing.T) {
+	s := NewStreamProvider(testObj.js)
+	if err := s.CreateStream(testObj.ctx, StreamSpec{Name: "EVENTS", Subjects: []string{"events.>"}}); err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "EVENTS")
+
+	for _, subject := range []string{"events.a", "events.b", "events.c", "events.a"} {
+		if _, err := s.PublishToStream(testObj.ctx, "EVENTS", subject, nil, nil); err != nil {
+			t.Fatalf("Error publishing: %v", err)
+		}
+	}
+
+	info, err := s.AddConsumer(testObj.ctx, "EVENTS", ConsumerSpec{
+		Durable:        "ab",
+		FilterSubjects: []string{"events.a", "events.b"},
+		DeliverPolicy:  DeliverByStartSequence,
+		StartSequence:  2,
+		AckWait:        time.Minute,
+		MaxDeliver:     4,
+		BackOff:        []time.Duration{time.Second, 5 * time.Second},
+		MaxAckPending:  100,
+	})
+	if err != nil {
+		t.Fatalf("Error adding consumer: %v", err)
+	}
+	if info.NumPending != 2 {
+		t.Fatalf("Expected 2 pending messages, got %d", info.NumPending)
+	}
+	if len(info.Spec.FilterSubjects) != 2 || info.Spec.DeliverPolicy != DeliverByStartSequence || info.Spec.AckPolicy != AckExplicit {
+		t.Fatalf("Unexpected consumer spec: %+v", info.Spec)
+	}
+
+	info.Spec.Description = "a and b events"
+	info.Spec.MaxAckPending = 10
+	updated, err := s.UpdateConsumer(testObj.ctx, "EVENTS", info.Spec)
+	if err != nil {
+		t.Fatalf("Error updating consumer: %v", err)
+	}
+	if updated.Spec.Description != "a and b events" || updated.Spec.MaxAckPending != 10 {
+		t.Fatalf("Consumer was not updated: %+v", updated.Spec)
+	}
+
+	if _, err := s.AddConsumer(testObj.ctx, "EVENTS", ConsumerSpec{Durable: "latest", FilterSubjects: []string{"events.>"}, DeliverPolicy: DeliverLastPerSubject}); err != nil {
+		t.Fatalf("Error adding consumer: %v", err)
+	}
+	consumers, err := s.ListConsumers(testObj.ctx, "EVENTS")
+	if err != nil {
+		t.Fatalf("Error listing consumers: %v", err)
+	}
+	if len(consumers) != 2 {
+		t.Fatalf("Expected 2 consumers, got %d", len(consumers))
+	}
+
+	if err := s.DeleteConsumer(testObj.ctx, "EVENTS", "ab"); err != nil {
+		t.Fatalf("Error deleting consumer: %v", err)
+	}
+	if _, err := s.ConsumerInfo(testObj.ctx, "EVENTS", "ab"); !errors.Is(err, nats.ErrConsumerNotFound) {
+		t.Fatalf("Expected ErrConsumerNotFound, got %v", err)
+	}
+	if _, err := s.ListConsumers(testObj.ctx, "MISSING"); !errors.Is(err, nats.ErrStreamNotFound) {
+		t.Fatalf("Expected ErrStreamNotFound, got %v", err)
+	}
+}
+
+func TestCreateOrUpdateStream(t *testing.T) {
+	s := NewStreamProvider(testObj.js)
+	spec := StreamSpec{
+		Name:              "METRICS",
+		Subjects:          []string{"metrics.>"},
+		Retention:         LimitsRetention,
+		MaxMsgs:           1000,
+		MaxAge:            time.Hour,
+		MaxMsgsPerSubject: 10,
+		Discard:           DiscardNew,
+		Duplicates:        time.Minute,
+		Compression:       S2Compression,
+		SubjectTransform:  &SubjectTransform{Source: "metrics.>", Destination: "stored.metrics.>"},
+		AllowRollup:       true,
+		DenyDelete:        true,
+	}
+	defer s.DeleteStream(testObj.ctx, "METRICS")
+
+	update, err := s.CreateOrUpdateStream(testObj.ctx, spec)
+	if err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	if !update.Created {
+		t.Fatal("Expected stream to be created")
+	}
+
+	update, err = s.CreateOrUpdateStream(testObj.ctx, spec)
+	if err != nil {
+		t.Fatalf("Error re-applying stream: %v", err)
+	}
+	if update.Created || len(update.Changes) != 0 {
+		t.Fatalf("Expected no changes, got %+v", update)
+	}
+
+	spec.MaxMsgs = 0
+	spec.MaxAge = 2 * time.Hour
+	update, err = s.CreateOrUpdateStream(testObj.ctx, spec)
+	if err != nil {
+		t.Fatalf("Error updating stream: %v", err)
+	}
+	if len(update.Changes) != 2 || update.Changes[0].Field != "MaxMsgs" || update.Changes[1].Field != "MaxAge" {
+		t.Fatalf("Unexpected changes: %+v", update.Changes)
+	}
+
+	info, err := testObj.js.StreamInfo("METRICS")
+	if err != nil {
+		t.Fatalf("Error getting stream info: %v", err)
+	}
+	if info.Config.MaxMsgs != -1 || info.Config.MaxAge != 2*time.Hour || info.Config.Compression != nats.S2Compression {
+		t.Fatalf("Stream config was not applied: %+v", info.Config)
+	}
+}
+
+func TestPublishToStream(t *testing.T) {
+	s := NewStreamProvider(testObj.js)
+	if err := s.CreateStream(testObj.ctx, StreamSpec{Name: "INGEST", Subjects: []string{"ingest.>"}}); err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "INGEST")
+
+	ack, err := s.PublishToStream(testObj.ctx, "INGEST", "ingest.a", []byte("one"), nil, WithMsgID("one"))
+	if err != nil {
+		t.Fatalf("Error publishing: %v", err)
+	}
+	if ack.Stream != "INGEST" || ack.Sequence != 1 || ack.Duplicate {
+		t.Fatalf("Unexpected ack: %+v", ack)
+	}
+
+	ack, err = s.PublishToStream(testObj.ctx, "INGEST", "ingest.a", []byte("one"), nil, WithMsgID("one"))
+	if err != nil {
+		t.Fatalf("Error publishing duplicate: %v", err)
+	}
+	if !ack.Duplicate {
+		t.Fatal("Expected duplicate ack")
+	}
+
+	ack, err = s.PublishToStream(testObj.ctx, "OTHER", "ingest.a", nil, nil)
+	if !errors.Is(err, ErrUnexpectedStream) || ack == nil || ack.Stream != "INGEST" {
+		t.Fatalf("Expected ErrUnexpectedStream with the stored ack, got %+v, %v", ack, err)
+	}
+	if _, err := s.PublishToStream(testObj.ctx, "", "ingest.a", nil, nil, WithExpectStream("OTHER")); err == nil {
+		t.Fatal("Expected error for wrong expected stream")
+	}
+	if _, err := s.PublishToStream(testObj.ctx, "INGEST", "ingest.a", nil, nil, WithExpectLastSequence(5)); err == nil {
+		t.Fatal("Expected error for wrong last sequence")
+	}
+	if _, err := s.PublishToStream(testObj.ctx, "INGEST", "ingest.b", nil, nil, WithExpectLastSequencePerSubject(0)); err != nil {
+		t.Fatalf("Error publishing first message of subject: %v", err)
+	}
+
+	publisher := s.AsyncPublisher("INGEST", 16)
+	futures := make([]*PubAckFuture, 0, 500)
+	for i := range 500 {
+		f, err := publisher.Publish(testObj.ctx, "ingest.async", []byte{byte(i)}, nil)
+		if err != nil {
+			t.Fatalf("Error publishing async: %v", err)
+		}
+		futures = append(futures, f)
+	}
+
+	ctx, cancel := context.WithTimeout(testObj.ctx, 5*time.Second)
+	defer cancel()
+	if err := publisher.Wait(ctx); err != nil {
+		t.Fatalf("Error waiting for acks: %v", err)
+	}
+
+	var last uint64
+	for _, f := range futures {
+		ack, err := f.Result(ctx)
+		if err != nil {
+			t.Fatalf("Async publish failed: %v", err)
+		}
+		if ack.Sequence <= last {
+			t.Fatalf("Expected increasing sequences, got %d after %d", ack.Sequence, last)
+		}
+		last = ack.Sequence
+	}
+}
+
+func TestAsyncPublisherAckTimeout(t *testing.T) {
+	nc, err := nats.Connect(testObj.url)
+	if err != nil {
+		t.Fatalf("Error connecting: %v", err)
+	}
+	defer nc.Close()
+
+	// A plain subscriber receives the publishes but never acks them.
+	sub, err := nc.SubscribeSync("silent.>")
+	if err != nil {
+		t.Fatalf("Error subscribing: %v", err)
+	}
+	defer sub.Unsubscribe()
+	if err := nc.Flush(); err != nil {
+		t.Fatalf("Error flushing: %v", err)
+	}
+
+	publisher := NewStreamProvider(testObj.js).AsyncPublisher("", 1, WithAckTimeout(50*time.Millisecond))
+	f, err := publisher.Publish(testObj.ctx, "silent.a", nil, nil)
+	if err != nil {
+		t.Fatalf("Error publishing async: %v", err)
+	}
+	if _, err := f.Result(testObj.ctx); !errors.Is(err, nats.ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+
+	// The timed out publish gave its only slot back.
+	ctx, cancel := context.WithTimeout(testObj.ctx, time.Second)
+	defer cancel()
+	f, err = publisher.Publish(ctx, "silent.b", nil, nil)
+	if err != nil {
+		t.Fatalf("Expected the slot to be free again, got %v", err)
+	}
+	if err := publisher.Wait(ctx); err != nil {
+		t.Fatalf("Error waiting for the publisher: %v", err)
+	}
+	if _, err := f.Result(ctx); !errors.Is(err, nats.ErrTimeout) {
+		t.Fatalf("Expected ErrTimeout, got %v", err)
+	}
+}
+
+func TestStreamMessageOperations(t *testing.T) {
+	s := NewStreamProvider(testObj.js)
+	if err := s.CreateStream(testObj.ctx, StreamSpec{Name: "AUDIT", Subjects: []string{"audit.>"}}); err != nil {
+		t.Fatalf("Error creating stream: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "AUDIT")
+
+	for i, subject := range []string{"audit.a", "audit.b", "audit.a", "audit.c", "audit.a", "audit.b"} {
+		if _, err := s.PublishToStream(testObj.ctx, "AUDIT", subject, []byte{byte(i)}, map[string]string{"Index": string(rune('0' + i))}); err != nil {
+			t.Fatalf("Error publishing: %v", err)
+		}
+	}
+
+	msg, err := s.GetMessage(testObj.ctx, "AUDIT", 2)
+	if err != nil {
+		t.Fatalf("Error getting message: %v", err)
+	}
+	if msg.Subject != "audit.b" || msg.Sequence != 2 || msg.Headers["Index"] != "1" {
+		t.Fatalf("Unexpected message: %+v", msg)
+	}
+
+	last, err := s.GetLastMessageForSubject(testObj.ctx, "AUDIT", "audit.a")
+	if err != nil {
+		t.Fatalf("Error getting last message: %v", err)
+	}
+	if last.Sequence != 5 {
+		t.Fatalf("Expected last audit.a at sequence 5, got %d", last.Sequence)
+	}
+
+	if err := s.DeleteMessage(testObj.ctx, "AUDIT", 4, true); err != nil {
+		t.Fatalf("Error deleting message: %v", err)
+	}
+	if _, err := s.GetMessage(testObj.ctx, "AUDIT", 4); !errors.Is(err, nats.ErrMsgNotFound) {
+		t.Fatalf("Expected ErrMsgNotFound, got %v", err)
+	}
+
+	info, err := s.StreamInfo(testObj.ctx, "AUDIT", ">")
+	if err != nil {
+		t.Fatalf("Error getting stream info: %v", err)
+	}
+	if info.Msgs != 5 || info.NumDeleted != 1 || info.Subjects["audit.a"] != 3 || info.Subjects["audit.b"] != 2 {
+		t.Fatalf("Unexpected stream info: %+v", info)
+	}
+
+	if err := s.PurgeStream(testObj.ctx, "AUDIT", WithPurgeSubject("audit.a"), WithPurgeKeep(1)); err != nil {
+		t.Fatalf("Error purging subject: %v", err)
+	}
+	if err := s.PurgeStream(testObj.ctx, "AUDIT", WithPurgeUpToSequence(3)); err != nil {
+		t.Fatalf("Error purging up to sequence: %v", err)
+	}
+
+	info, err = s.StreamInfo(testObj.ctx, "AUDIT", "audit.*")
+	if err != nil {
+		t.Fatalf("Error getting stream info: %v", err)
+	}
+	if info.Msgs != 2 || info.FirstSeq != 5 || info.Subjects["audit.a"] != 1 || info.Subjects["audit.b"] != 1 {
+		t.Fatalf("Unexpected stream info after purge: %+v", info)
+	}
+}
+
+func TestSourceAndMirrorStreams(t *testing.T) {
+	s := NewStreamProvider(testObj.js)
+	for _, region := range []string{"EU", "US"} {
+		subjects := []string{"region." + region + ".>"}
+		if err := s.CreateStream(testObj.ctx, StreamSpec{Name: region, Subjects: subjects}); err != nil {
+			t.Fatalf("Error creating stream: %v", err)
+		}
+		defer s.DeleteStream(testObj.ctx, region)
+
+		for _, kind := range []string{"orders", "logs", "orders"} {
+			if _, err := s.PublishToStream(testObj.ctx, region, "region."+region+"."+kind, nil, nil); err != nil {
+				t.Fatalf("Error publishing: %v", err)
+			}
+		}
+	}
+
+	err := s.CreateSourceStream(testObj.ctx, StreamSpec{Name: "CENTRAL"},
+		StreamSource{Name: "EU", FilterSubject: "region.EU.orders"},
+		StreamSource{Name: "US", StartSequence: 2, SubjectTransforms: []SubjectTransform{
+			{Source: "region.US.>", Destination: "central.US.>"},
+		}},
+	)
+	if err != nil {
+		t.Fatalf("Error creating source stream: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "CENTRAL")
+
+	// Two sources from the same stream, told apart by their subjects.
+	err = s.CreateSourceStream(testObj.ctx, StreamSpec{Name: "SPLIT"},
+		StreamSource{Name: "US", FilterSubject: "region.US.orders"},
+		StreamSource{Name: "US", SubjectTransforms: []SubjectTransform{
+			{Source: "region.US.logs", Destination: "split.logs"},
+		}},
+	)
+	if err != nil {
+		t.Fatalf("Error creating source stream from one origin: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "SPLIT")
+
+	// The server may list sources in any order; each one must still be
+	// matched to the source with the same subjects.
+	addErr := errors.New("add failed")
+	info, err := testObj.js.StreamInfo("SPLIT")
+	if err != nil {
+		t.Fatalf("Error getting stream info: %v", err)
+	}
+	cfg := info.Config
+	cfg.Sources = []*nats.StreamSource{cfg.Sources[1], cfg.Sources[0]}
+	if err := s.(*streamProvider).checkSourceTransforms(testObj.ctx, &cfg, addErr); err != nil {
+		t.Fatalf("Expected the stored sources to match, got %v", err)
+	}
+	cfg.Sources = []*nats.StreamSource{cfg.Sources[0], cfg.Sources[0]}
+	if err := s.(*streamProvider).checkSourceTransforms(testObj.ctx, &cfg, addErr); !errors.Is(err, addErr) {
+		t.Fatalf("Expected a mismatch for a duplicated source, got %v", err)
+	}
+
+	if err := s.CreateMirrorStream(testObj.ctx, StreamSpec{Name: "EU_MIRROR"}, StreamSource{Name: "EU", StartSequence: 2}); err != nil {
+		t.Fatalf("Error creating mirror stream: %v", err)
+	}
+	defer s.DeleteStream(testObj.ctx, "EU_MIRROR")
+
+	if err := s.CreateMirrorStream(testObj.ctx, StreamSpec{Name: "BAD", Subjects: []string{"bad"}}, StreamSource{Name: "EU"}); err == nil {
+		t.Fatal("Expected error creating mirror with subjects")
+	}
+
+	waitForMsgs := func(stream string, want uint64) *StreamInfo {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			info, err := s.StreamInfo(testObj.ctx, stream, ">")
+			if err != nil {
+				t.Fatalf("Error getting stream info: %v", err)
+			}
+			if info.Msgs == want {
+				return info
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected %d messages in %s, got %d", want, stream, info.Msgs)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	central := waitForMsgs("CENTRAL", 4)
+	if central.Subjects["region.EU.orders"] != 2 || central.Subjects["central.US.logs"] != 1 || central.Subjects["central.US.orders"] != 1 {
+		t.Fatalf("Unexpected aggregated subjects: %v", central.Subjects)
+	}
+	split := waitForMsgs("SPLIT", 3)
+	if split.Subjects["region.US.orders"] != 2 || split.Subjects["split.logs"] != 1 {
+		t.Fatalf("Unexpected split subjects: %v", split.Subjects)
+	}
+	waitForMsgs("EU_MIRROR", 2)
+}
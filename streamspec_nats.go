@@ -0,0 +1,313 @@
+package natsprovider
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// RetentionPolicy decides when messages are removed from a stream.
+type RetentionPolicy int
+
+const (
+	// LimitsRetention keeps messages until a stream limit is reached.
+	LimitsRetention RetentionPolicy = iota
+	// InterestRetention keeps messages while a consumer has yet to ack them.
+	InterestRetention
+	// WorkQueueRetention removes each message once it is acked.
+	WorkQueueRetention
+)
+
+var retentionPolicies = map[RetentionPolicy]nats.RetentionPolicy{
+	LimitsRetention:    nats.LimitsPolicy,
+	InterestRetention:  nats.InterestPolicy,
+	WorkQueueRetention: nats.WorkQueuePolicy,
+}
+
+// DiscardPolicy decides which messages go once a stream is full.
+type DiscardPolicy int
+
+const (
+	DiscardOld DiscardPolicy = iota
+	DiscardNew
+)
+
+var discardPolicies = map[DiscardPolicy]nats.DiscardPolicy{
+	DiscardOld: nats.DiscardOld,
+	DiscardNew: nats.DiscardNew,
+}
+
+// StorageType selects the stream storage backend.
+type StorageType int
+
+const (
+	FileStorage StorageType = iota
+	MemoryStorage
+)
+
+var storageTypes = map[StorageType]nats.StorageType{
+	FileStorage:   nats.FileStorage,
+	MemoryStorage: nats.MemoryStorage,
+}
+
+// Compression selects on-disk compression for file storage.
+type Compression int
+
+const (
+	NoCompression Compression = iota
+	S2Compression
+)
+
+var compressions = map[Compression]nats.StoreCompression{
+	NoCompression: nats.NoCompression,
+	S2Compression: nats.S2Compression,
+}
+
+// SubjectTransform rewrites subjects matching Source to Destination as
+// messages are stored.
+type SubjectTransform struct {
+	Source      string
+	Destination string
+}
+
+// StreamSource is an upstream stream copied into a mirror or aggregate
+// stream.
+type StreamSource struct {
+	Name string
+	// StartSequence or StartTime skip older upstream messages.
+	StartSequence uint64
+	StartTime     time.Time
+	// FilterSubject copies only matching messages. It cannot be combined
+	// with SubjectTransforms, which carry their own source filters.
+	FilterSubject     string
+	SubjectTransforms []SubjectTransform
+	// Domain reads the source from another JetStream domain. APIPrefix and
+	// DeliverPrefix reach a stream in another account instead.
+	Domain        string
+	APIPrefix     string
+	DeliverPrefix string
+}
+
+func (src StreamSource) config() *nats.StreamSource {
+	cfg := &nats.StreamSource{
+		Name:          src.Name,
+		OptStartSeq:   src.StartSequence,
+		FilterSubject: src.FilterSubject,
+		Domain:        src.Domain,
+	}
+	if !src.StartTime.IsZero() {
+		startTime := src.StartTime
+		cfg.OptStartTime = &startTime
+	}
+	for _, transform := range src.SubjectTransforms {
+		cfg.SubjectTransforms = append(cfg.SubjectTransforms, nats.SubjectTransformConfig{
+			Source:      transform.Source,
+			Destination: transform.Destination,
+		})
+	}
+	if src.APIPrefix != "" {
+		cfg.External = &nats.ExternalStream{
+			APIPrefix:     src.APIPrefix,
+			DeliverPrefix: src.DeliverPrefix,
+		}
+	}
+	return cfg
+}
+
+// StreamSpec describes a stream. Zero limits mean unlimited and a zero
+// Duplicates window keeps the server default.
+type StreamSpec struct {
+	Name              string
+	Description       string
+	Subjects          []string
+	Retention         RetentionPolicy
+	Storage           StorageType
+	MaxMsgs           int64
+	MaxBytes          int64
+	MaxAge            time.Duration
+	MaxMsgsPerSubject int64
+	MaxMsgSize        int32
+	Discard           DiscardPolicy
+	// DiscardNewPerSubject applies DiscardNew per subject instead of to
+	// the whole stream.
+	DiscardNewPerSubject bool
+	Duplicates           time.Duration
+	Replicas             int
+	Compression          Compression
+	SubjectTransform     *SubjectTransform
+	AllowRollup          bool
+	DenyDelete           bool
+	DenyPurge            bool
+}
+
+func (spec StreamSpec) config() (*nats.StreamConfig, error) {
+	retention, ok := retentionPolicies[spec.Retention]
+	if !ok {
+		return nil, fmt.Errorf("unknown retention policy %d", spec.Retention)
+	}
+	discard, ok := discardPolicies[spec.Discard]
+	if !ok {
+		return nil, fmt.Errorf("unknown discard policy %d", spec.Discard)
+	}
+	storage, ok := storageTypes[spec.Storage]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage type %d", spec.Storage)
+	}
+	compression, ok := compressions[spec.Compression]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression %d", spec.Compression)
+	}
+
+	cfg := &nats.StreamConfig{
+		Name:                 spec.Name,
+		Description:          spec.Description,
+		Subjects:             spec.Subjects,
+		Retention:            retention,
+		Storage:              storage,
+		MaxMsgs:              unlimited(spec.MaxMsgs),
+		MaxBytes:             unlimited(spec.MaxBytes),
+		MaxAge:               spec.MaxAge,
+		MaxMsgsPerSubject:    unlimited(spec.MaxMsgsPerSubject),
+		MaxMsgSize:           int32(unlimited(int64(spec.MaxMsgSize))),
+		Discard:              discard,
+		DiscardNewPerSubject: spec.DiscardNewPerSubject,
+		Duplicates:           spec.Duplicates,
+		Replicas:             max(spec.Replicas, 1),
+		Compression:          compression,
+		AllowRollup:          spec.AllowRollup,
+		DenyDelete:           spec.DenyDelete,
+		DenyPurge:            spec.DenyPurge,
+	}
+	if spec.SubjectTransform != nil {
+		cfg.SubjectTransform = &nats.SubjectTransformConfig{
+			Source:      spec.SubjectTransform.Source,
+			Destination: spec.SubjectTransform.Destination,
+		}
+	}
+	return cfg, nil
+}
+
+func streamSpecFromConfig(cfg nats.StreamConfig) StreamSpec {
+	spec := StreamSpec{
+		Name:                 cfg.Name,
+		Description:          cfg.Description,
+		Subjects:             cfg.Subjects,
+		MaxMsgs:              limit(cfg.MaxMsgs),
+		MaxBytes:             limit(cfg.MaxBytes),
+		MaxAge:               cfg.MaxAge,
+		MaxMsgsPerSubject:    limit(cfg.MaxMsgsPerSubject),
+		MaxMsgSize:           int32(limit(int64(cfg.MaxMsgSize))),
+		DiscardNewPerSubject: cfg.DiscardNewPerSubject,
+		Duplicates:           cfg.Duplicates,
+		Replicas:             cfg.Replicas,
+		AllowRollup:          cfg.AllowRollup,
+		DenyDelete:           cfg.DenyDelete,
+		DenyPurge:            cfg.DenyPurge,
+	}
+	for policy, natsPolicy := range retentionPolicies {
+		if natsPolicy == cfg.Retention {
+			spec.Retention = policy
+		}
+	}
+	for policy, natsPolicy := range discardPolicies {
+		if natsPolicy == cfg.Discard {
+			spec.Discard = policy
+		}
+	}
+	for storage, natsStorage := range storageTypes {
+		if natsStorage == cfg.Storage {
+			spec.Storage = storage
+		}
+	}
+	for compression, natsCompression := range compressions {
+		if natsCompression == cfg.Compression {
+			spec.Compression = compression
+		}
+	}
+	if cfg.SubjectTransform != nil {
+		spec.SubjectTransform = &SubjectTransform{
+			Source:      cfg.SubjectTransform.Source,
+			Destination: cfg.SubjectTransform.Destination,
+		}
+	}
+	return spec
+}
+
+// keepUnmodeled copies the settings StreamSpec does not describe from the
+// existing config, so updating a stream leaves them untouched.
+func keepUnmodeled(cfg *nats.StreamConfig, existing nats.StreamConfig) {
+	if cfg.Duplicates == 0 {
+		cfg.Duplicates = existing.Duplicates
+	}
+	cfg.MaxConsumers = existing.MaxConsumers
+	cfg.NoAck = existing.NoAck
+	cfg.Placement = existing.Placement
+	cfg.Mirror = existing.Mirror
+	cfg.Sources = existing.Sources
+	cfg.Sealed = existing.Sealed
+	cfg.FirstSeq = existing.FirstSeq
+	cfg.RePublish = existing.RePublish
+	cfg.AllowDirect = existing.AllowDirect
+	cfg.MirrorDirect = existing.MirrorDirect
+	cfg.ConsumerLimits = existing.ConsumerLimits
+	cfg.Metadata = existing.Metadata
+	cfg.AllowMsgTTL = existing.AllowMsgTTL
+	cfg.SubjectDeleteMarkerTTL = existing.SubjectDeleteMarkerTTL
+}
+
+// unlimited maps the spec's zero limit to the server's -1.
+func unlimited(v int64) int64 {
+	if v <= 0 {
+		return -1
+	}
+	return v
+}
+
+// limit maps the server's -1 limit back to the spec's zero.
+func limit(v int64) int64 {
+	if v < 0 {
+		return 0
+	}
+	return v
+}
+
+// StreamChange is a single field changed by CreateOrUpdateStream.
+type StreamChange struct {
+	Field string
+	Old   any
+	New   any
+}
+
+// StreamUpdate reports what CreateOrUpdateStream did.
+type StreamUpdate struct {
+	Created bool
+	Changes []StreamChange
+}
+
+// diffStreamSpecs lists the fields of desired that differ from current.
+// Zero values that the server fills in itself are not reported.
+func diffStreamSpecs(current, desired StreamSpec) []StreamChange {
+	desired.Replicas = max(desired.Replicas, 1)
+	if desired.Duplicates == 0 {
+		desired.Duplicates = current.Duplicates
+	}
+	if len(desired.Subjects) == 0 && len(current.Subjects) == 0 {
+		desired.Subjects = current.Subjects
+	}
+
+	var changes []StreamChange
+	cv, dv := reflect.ValueOf(current), reflect.ValueOf(desired)
+	for i := range cv.NumField() {
+		oldValue, newValue := cv.Field(i).Interface(), dv.Field(i).Interface()
+		if !reflect.DeepEqual(oldValue, newValue) {
+			changes = append(changes, StreamChange{
+				Field: cv.Type().Field(i).Name,
+				Old:   oldValue,
+				New:   newValue,
+			})
+		}
+	}
+	return changes
+}
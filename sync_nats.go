@@ -0,0 +1,621 @@
+package natsprovider
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	defaultSyncConcurrency = 4
+	syncTempPattern        = ".natsprovider-sync-*"
+	syncStateInterval      = time.Second
+)
+
+// SyncOp is the kind of change a sync makes.
+type SyncOp int
+
+const (
+	SyncUpload SyncOp = iota
+	SyncDownload
+	SyncDeleteObject
+	SyncDeleteFile
+)
+
+func (op SyncOp) String() string {
+	switch op {
+	case SyncUpload:
+		return "upload"
+	case SyncDownload:
+		return "download"
+	case SyncDeleteObject:
+		return "delete object"
+	case SyncDeleteFile:
+		return "delete file"
+	}
+	return fmt.Sprintf("SyncOp(%d)", int(op))
+}
+
+// SyncAction is one step of a sync plan.
+type SyncAction struct {
+	Op SyncOp
+	// Path is the slash-separated file path relative to the synced directory.
+	Path   string
+	Object string
+	Size   int64
+}
+
+// SyncResult describes a finished, failed or dry sync.
+type SyncResult struct {
+	// Actions is the change plan. With WithSyncDryRun none of it was applied.
+	Actions []SyncAction
+	// Unchanged counts the files that were already in sync.
+	Unchanged int
+	// Transferred is the number of bytes uploaded or downloaded.
+	Transferred int64
+}
+
+// SyncOption configures SyncToBucket and SyncFromBucket.
+type SyncOption func(*syncOptions)
+
+type syncOptions struct {
+	prefix      string
+	include     []string
+	exclude     []string
+	delete      bool
+	dryRun      bool
+	concurrency int
+	stateFile   string
+	progress    func(SyncAction, error)
+}
+
+// WithSyncPrefix maps the directory to the objects whose names start with
+// prefix, e.g. "cache/linux/".
+func WithSyncPrefix(prefix string) SyncOption {
+	return func(o *syncOptions) {
+		o.prefix = prefix
+	}
+}
+
+// WithSyncInclude limits the sync to paths matching at least one pattern.
+// Patterns use path.Match syntax. A pattern without a slash matches any
+// element of the path, like "*.o"; one with a slash matches the path from the
+// directory root or any of its parents, like "build/*".
+func WithSyncInclude(patterns ...string) SyncOption {
+	return func(o *syncOptions) {
+		o.include = append(o.include, patterns...)
+	}
+}
+
+// WithSyncExclude skips paths matching any pattern, with the same syntax as
+// WithSyncInclude. Excluded files are never deleted.
+func WithSyncExclude(patterns ...string) SyncOption {
+	return func(o *syncOptions) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// WithSyncDelete removes what exists only on the destination side.
+func WithSyncDelete() SyncOption {
+	return func(o *syncOptions) {
+		o.delete = true
+	}
+}
+
+// WithSyncDryRun computes the plan without changing anything.
+func WithSyncDryRun() SyncOption {
+	return func(o *syncOptions) {
+		o.dryRun = true
+	}
+}
+
+// WithSyncConcurrency bounds the number of transfers running at once.
+func WithSyncConcurrency(n int) SyncOption {
+	return func(o *syncOptions) {
+		o.concurrency = n
+	}
+}
+
+// WithSyncState records the digest of every local file in file. An
+// interrupted sync run again with the same state file skips hashing the files
+// that have not changed since, and skips the transfers that already finished.
+func WithSyncState(file string) SyncOption {
+	return func(o *syncOptions) {
+		o.stateFile = file
+	}
+}
+
+// WithSyncProgress calls fn after each action is applied, with its error if
+// any. fn may be called from several goroutines at once.
+func WithSyncProgress(fn func(SyncAction, error)) SyncOption {
+	return func(o *syncOptions) {
+		o.progress = fn
+	}
+}
+
+// SyncToBucket uploads the files under dir that are missing from store or
+// whose SHA-256 differs from the object digest.
+func SyncToBucket(ctx context.Context, store ObjectStoreProvider, dir string, opts ...SyncOption) (*SyncResult, error) {
+	s, err := newSyncer(store, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := s.localFiles()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := s.remoteObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{}
+	for _, rel := range sortedKeys(local) {
+		fi := local[rel]
+		obj := remote[rel]
+		if obj != nil && obj.Size == uint64(fi.Size()) {
+			digest, err := s.state.digest(s.dir, rel, fi)
+			if err != nil {
+				return nil, err
+			}
+			if digest == obj.Digest {
+				result.Unchanged++
+				continue
+			}
+		}
+		result.Actions = append(result.Actions, SyncAction{Op: SyncUpload, Path: rel, Object: s.opts.prefix + rel, Size: fi.Size()})
+	}
+	if s.opts.delete {
+		for _, rel := range sortedKeys(remote) {
+			if _, ok := local[rel]; !ok {
+				result.Actions = append(result.Actions, SyncAction{Op: SyncDeleteObject, Path: rel, Object: remote[rel].Name, Size: int64(remote[rel].Size)})
+			}
+		}
+	}
+
+	return s.run(ctx, result)
+}
+
+// SyncFromBucket downloads the objects that are missing from dir or whose
+// digest differs from the local file's SHA-256. Files are written to a
+// temporary name and renamed once complete, so an interrupted download never
+// leaves a partial file behind. Links and objects whose names are not valid
+// local paths are skipped.
+func SyncFromBucket(ctx context.Context, store ObjectStoreProvider, dir string, opts ...SyncOption) (*SyncResult, error) {
+	s, err := newSyncer(store, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := s.localFiles()
+	if errors.Is(err, fs.ErrNotExist) {
+		local, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	remote, err := s.remoteObjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{}
+	for _, rel := range sortedKeys(remote) {
+		obj := remote[rel]
+		if fi, ok := local[rel]; ok && obj.Size == uint64(fi.Size()) {
+			digest, err := s.state.digest(s.dir, rel, fi)
+			if err != nil {
+				return nil, err
+			}
+			if digest == obj.Digest {
+				result.Unchanged++
+				continue
+			}
+		}
+		result.Actions = append(result.Actions, SyncAction{Op: SyncDownload, Path: rel, Object: obj.Name, Size: int64(obj.Size)})
+	}
+	if s.opts.delete {
+		for _, rel := range sortedKeys(local) {
+			if _, ok := remote[rel]; !ok {
+				result.Actions = append(result.Actions, SyncAction{Op: SyncDeleteFile, Path: rel, Object: s.opts.prefix + rel, Size: local[rel].Size()})
+			}
+		}
+	}
+
+	return s.run(ctx, result)
+}
+
+type syncer struct {
+	store ObjectStoreProvider
+	dir   string
+	opts  syncOptions
+	state *syncState
+}
+
+func newSyncer(store ObjectStoreProvider, dir string, opts []SyncOption) (*syncer, error) {
+	o := syncOptions{concurrency: defaultSyncConcurrency}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.concurrency < 1 {
+		return nil, fmt.Errorf("sync concurrency must be positive, got %d", o.concurrency)
+	}
+	for _, pattern := range slices.Concat(o.include, o.exclude) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("sync pattern %q: %w", pattern, err)
+		}
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadSyncState(o.stateFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &syncer{store: store, dir: dir, opts: o, state: state}, nil
+}
+
+func (s *syncer) run(ctx context.Context, result *SyncResult) (*SyncResult, error) {
+	if s.opts.dryRun {
+		return result, s.state.save()
+	}
+
+	var (
+		wg       sync.WaitGroup
+		lock     sync.Mutex
+		errs     []error
+		canceled error // kept apart from errs, which the workers append to
+		slots    = make(chan struct{}, s.opts.concurrency)
+	)
+	for _, action := range result.Actions {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if canceled = ctx.Err(); canceled != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			n, err := s.apply(ctx, action)
+			if err != nil {
+				err = fmt.Errorf("%s %s: %w", action.Op, action.Path, err)
+			}
+
+			lock.Lock()
+			result.Transferred += n
+			if err != nil {
+				errs = append(errs, err)
+			}
+			lock.Unlock()
+
+			if s.opts.progress != nil {
+				s.opts.progress(action, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	errs = append(errs, canceled)
+	if err := s.state.save(); err != nil {
+		errs = append(errs, err)
+	}
+	return result, errors.Join(errs...)
+}
+
+func (s *syncer) apply(ctx context.Context, action SyncAction) (int64, error) {
+	target := filepath.Join(s.dir, filepath.FromSlash(action.Path))
+
+	switch action.Op {
+	case SyncUpload:
+		f, err := os.Open(target)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		info, err := s.store.PutObjectStream(ctx, action.Object, f, ObjectMeta{})
+		if err != nil {
+			return 0, err
+		}
+		s.state.set(action.Path, fi, info.Digest)
+		return int64(info.Size), nil
+
+	case SyncDownload:
+		return s.download(ctx, action, target)
+
+	case SyncDeleteObject:
+		return 0, s.store.DeleteObject(ctx, action.Object)
+
+	case SyncDeleteFile:
+		s.state.remove(action.Path)
+		return 0, os.Remove(target)
+	}
+	return 0, fmt.Errorf("unknown sync op %v", action.Op)
+}
+
+func (s *syncer) download(ctx context.Context, action SyncAction, target string) (n int64, err error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), syncTempPattern)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+
+	r, info, err := s.store.GetObjectStream(ctx, action.Object)
+	if err != nil {
+		return 0, err
+	}
+	n, err = io.Copy(tmp, r)
+	if closeErr := r.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := tmp.Close(); err != nil {
+		return n, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return n, err
+	}
+
+	fi, err := os.Stat(target)
+	if err != nil {
+		return n, err
+	}
+	s.state.set(action.Path, fi, info.Digest)
+	return n, nil
+}
+
+// localFiles returns the selected regular files under the directory, keyed by
+// slash-separated relative path.
+func (s *syncer) localFiles() (map[string]fs.FileInfo, error) {
+	files := make(map[string]fs.FileInfo)
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel != "." && s.opts.excluded(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || s.state.owns(p) {
+			return nil
+		}
+		if ok, _ := filepath.Match(syncTempPattern, d.Name()); ok {
+			return nil
+		}
+		if !s.opts.selected(rel) {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[rel] = fi
+		return nil
+	})
+	return files, err
+}
+
+// remoteObjects returns the selected objects under the prefix, keyed by
+// slash-separated path relative to the prefix.
+func (s *syncer) remoteObjects(ctx context.Context) (map[string]*ObjectInfo, error) {
+	infos, err := s.store.ListObjectInfo(ctx)
+	if err != nil && !errors.Is(err, nats.ErrNoObjectsFound) {
+		return nil, err
+	}
+
+	objects := make(map[string]*ObjectInfo, len(infos))
+	for _, info := range infos {
+		rel, ok := strings.CutPrefix(info.Name, s.opts.prefix)
+		if !ok || info.Link != nil || !filepath.IsLocal(filepath.FromSlash(rel)) || strings.Contains(rel, `\`) {
+			continue
+		}
+		if s.opts.selected(rel) {
+			objects[rel] = info
+		}
+	}
+	return objects, nil
+}
+
+func (o *syncOptions) selected(rel string) bool {
+	match := func(pattern string) bool { return matchSyncPattern(pattern, rel) }
+	if len(o.include) > 0 && !slices.ContainsFunc(o.include, match) {
+		return false
+	}
+	return !o.excluded(rel)
+}
+
+func (o *syncOptions) excluded(rel string) bool {
+	return slices.ContainsFunc(o.exclude, func(pattern string) bool {
+		return matchSyncPattern(pattern, rel)
+	})
+}
+
+func matchSyncPattern(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		for _, elem := range strings.Split(rel, "/") {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
+			}
+		}
+		return false
+	}
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if ok, _ := path.Match(pattern, p); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// syncState caches local file digests by size and modification time.
+type syncState struct {
+	path  string
+	lock  sync.Mutex
+	saved time.Time
+	Files map[string]syncStateEntry `json:"files"`
+}
+
+type syncStateEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Digest  string    `json:"digest"`
+}
+
+func loadSyncState(file string) (*syncState, error) {
+	state := &syncState{Files: make(map[string]syncStateEntry)}
+	if file == "" {
+		return state, nil
+	}
+
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	state.path = abs
+
+	data, err := os.ReadFile(abs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("sync state %q: %w", file, err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]syncStateEntry)
+	}
+	return state, nil
+}
+
+// digest returns the object store digest of a local file, hashing it only
+// when it changed since it was last recorded.
+func (s *syncState) digest(dir, rel string, fi fs.FileInfo) (string, error) {
+	s.lock.Lock()
+	entry, ok := s.Files[rel]
+	s.lock.Unlock()
+	if ok && entry.Size == fi.Size() && entry.ModTime.Equal(fi.ModTime()) {
+		return entry.Digest, nil
+	}
+
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	digest := "SHA-256=" + base64.URLEncoding.EncodeToString(h.Sum(nil))
+
+	s.lock.Lock()
+	s.Files[rel] = syncStateEntry{Size: fi.Size(), ModTime: fi.ModTime(), Digest: digest}
+	s.lock.Unlock()
+	return digest, nil
+}
+
+// set records a finished transfer and saves the state at most once per
+// syncStateInterval, so a crash loses little progress.
+func (s *syncState) set(rel string, fi fs.FileInfo, digest string) {
+	s.lock.Lock()
+	s.Files[rel] = syncStateEntry{Size: fi.Size(), ModTime: fi.ModTime(), Digest: digest}
+	due := time.Since(s.saved) >= syncStateInterval
+	s.lock.Unlock()
+
+	if due {
+		_ = s.save() // best effort, the final save reports errors
+	}
+}
+
+// owns reports whether file is the state file or its temporary copy, which
+// must not be synced when they live inside the synced directory.
+func (s *syncState) owns(file string) bool {
+	return s.path != "" && (file == s.path || file == s.path+".tmp")
+}
+
+func (s *syncState) remove(rel string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.Files, rel)
+}
+
+func (s *syncState) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.saved = time.Now()
+	return nil
+}
@@ -0,0 +1,165 @@
+package natsprovider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func syncOps(result *SyncResult) map[string]SyncOp {
+	ops := make(map[string]SyncOp, len(result.Actions))
+	for _, a := range result.Actions {
+		ops[a.Path] = a.Op
+	}
+	return ops
+}
+
+func TestSyncDirectory(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := NewObjectStoreProvider(testObj.js, "objects_sync")
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("objects_sync")
+
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"a.txt":             "alpha",
+		"sub/b.txt":         "bravo",
+		"sub/c.tmp":         "scratch",
+		"node_modules/x.js": "module",
+	})
+	opts := []SyncOption{
+		WithSyncPrefix("cache/"),
+		WithSyncExclude("*.tmp", "node_modules"),
+		WithSyncState(filepath.Join(src, ".sync-state")),
+	}
+
+	plan, err := SyncToBucket(ctx, store, src, append(opts, WithSyncDryRun())...)
+	if err != nil {
+		t.Fatalf("Error planning sync: %v", err)
+	}
+	if ops := syncOps(plan); len(ops) != 2 || ops["a.txt"] != SyncUpload || ops["sub/b.txt"] != SyncUpload {
+		t.Fatalf("Unexpected plan: %+v", plan.Actions)
+	}
+	if names, _ := store.ListObjects(ctx); len(names) != 0 {
+		t.Fatalf("Dry run uploaded %v", names)
+	}
+
+	var done atomic.Int32
+	result, err := SyncToBucket(ctx, store, src, append(opts,
+		WithSyncConcurrency(1),
+		WithSyncProgress(func(SyncAction, error) { done.Add(1) }),
+	)...)
+	if err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if result.Transferred != 10 || done.Load() != 2 {
+		t.Fatalf("Expected 10 bytes in 2 actions, got %d in %d", result.Transferred, done.Load())
+	}
+	if data, err := store.GetObject(ctx, "cache/sub/b.txt"); err != nil || string(data) != "bravo" {
+		t.Fatalf("Unexpected object: %q, %v", data, err)
+	}
+
+	result, err = SyncToBucket(ctx, store, src, opts...)
+	if err != nil || len(result.Actions) != 0 || result.Unchanged != 2 {
+		t.Fatalf("Expected nothing to sync, got %+v, %v", result, err)
+	}
+
+	writeFiles(t, src, map[string]string{"a.txt": "ALPHA"})
+	if err := os.Remove(filepath.Join(src, "sub", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = SyncToBucket(ctx, store, src, append(opts, WithSyncDelete())...)
+	if err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if ops := syncOps(result); len(ops) != 2 || ops["a.txt"] != SyncUpload || ops["sub/b.txt"] != SyncDeleteObject {
+		t.Fatalf("Unexpected actions: %+v", result.Actions)
+	}
+
+	if _, err := store.PutObject(ctx, "cache/deep/d.txt", []byte("delta")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.PutObject(ctx, "other/e.txt", []byte("echo")); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "restore")
+	writeFiles(t, dst, map[string]string{"stale.txt": "old", "keep.tmp": "kept"})
+	result, err = SyncFromBucket(ctx, store, dst, WithSyncPrefix("cache/"), WithSyncExclude("*.tmp"), WithSyncDelete())
+	if err != nil {
+		t.Fatalf("Error syncing from bucket: %v", err)
+	}
+	if ops := syncOps(result); len(ops) != 3 || ops["a.txt"] != SyncDownload || ops["deep/d.txt"] != SyncDownload || ops["stale.txt"] != SyncDeleteFile {
+		t.Fatalf("Unexpected actions: %+v", result.Actions)
+	}
+	for name, want := range map[string]string{"a.txt": "ALPHA", "deep/d.txt": "delta", "keep.tmp": "kept"} {
+		data, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil || string(data) != want {
+			t.Fatalf("Expected %s to contain %q, got %q, %v", name, want, data, err)
+		}
+	}
+	for _, name := range []string{"stale.txt", "e.txt", "other"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be absent, got %v", name, err)
+		}
+	}
+
+	if _, err := SyncToBucket(ctx, store, src, WithSyncInclude("[")); err == nil {
+		t.Fatal("Expected error for malformed pattern")
+	}
+}
+
+func TestSyncDirectoryCancel(t *testing.T) {
+	store, err := NewObjectStoreProvider(testObj.js, "objects_sync_cancel")
+	if err != nil {
+		t.Fatalf("Error creating object store: %v", err)
+	}
+	defer testObj.js.DeleteObjectStore("objects_sync_cancel")
+
+	src := t.TempDir()
+	files := make(map[string]string)
+	for i := range 50 {
+		files[fmt.Sprintf("f%02d.txt", i)] = strings.Repeat("x", i)
+	}
+	writeFiles(t, src, files)
+
+	// Cancel once the first transfers finish, while others are still running.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var done atomic.Int32
+	_, err = SyncToBucket(ctx, store, src,
+		WithSyncConcurrency(4),
+		WithSyncProgress(func(SyncAction, error) {
+			if done.Add(1) == 2 {
+				cancel()
+			}
+		}),
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if n := done.Load(); n == 0 || n >= int32(len(files)) {
+		t.Fatalf("Expected the sync to stop partway, got %d of %d actions", n, len(files))
+	}
+}